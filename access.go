@@ -2,6 +2,8 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
@@ -14,28 +16,64 @@ type SSHAccessDetails struct {
 	Idn  string `json:"idn"`
 }
 
+type MaintenanceWindow struct {
+	Id     string `json:"Id"`
+	Start  int    `json:"Start"`
+	End    int    `json:"End"`
+	Reason string `json:"Reason"`
+}
+
+// activeMaintenance returns the maintenance window the resource is in, so new
+// sessions can be refused with a readable message before touching the ledger.
+func activeMaintenance(id string) *MaintenanceWindow {
+	data, err := Query("QueryComputeRes", id)
+	if err != nil {
+		return nil
+	}
+
+	var res struct {
+		Maintenance []MaintenanceWindow `json:"Maintenance"`
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil
+	}
+
+	now := int(time.Now().UnixMicro())
+	for _, w := range res.Maintenance {
+		if w.Start <= now && now < w.End {
+			return &w
+		}
+	}
+
+	return nil
+}
+
 func connectToBackend(c *gin.Context) {
 
 	id := c.Param("id")
 
+	if w := activeMaintenance(id); w != nil {
+		c.JSON(503, gin.H{"err": fmt.Sprintf("the resource is under maintenance until %s: %s", time.UnixMicro(int64(w.End)).Format(time.RFC3339), w.Reason)})
+		return
+	}
+
 	details, err := Invoke("GetConnectDetails", id)
 
 	if err != nil {
-		log.Err(err).Str("data", string(details)).Msg("failed to get connection details")
+		log.Err(err).Msg("failed to get connection details")
 
 		c.JSON(400, gin.H{"err": err.Error()})
 		return
 	}
 
-	var sshDetails SSHAccessDetails
-
-	err = json.Unmarshal(details, &sshDetails)
+	sshDetails, err := openCredentials(details)
 
 	if err != nil {
-		log.Err(err).Str("data", string(details)).Msg("failed to unmarshal connection details")
-	}
+		log.Err(err).Str("id", id).Msg("failed to open connection details")
 
-	log.Printf("ssh details: %+v", sshDetails)
+		c.JSON(400, gin.H{"err": err.Error()})
+		return
+	}
 
 	hdr := sshHandler{
 		addr:    sshDetails.Addr,
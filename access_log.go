@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// logRetention is how long the org keeps access logs, empty keeps them
+// forever.
+var logRetention = ""
+
+// purgeAccessLogs deletes the org's access logs older than retention on
+// every resource.
+func purgeAccessLogs(retention time.Duration) {
+	before := time.Now().Add(-retention)
+
+	data, err := Invoke("PurgeConnectionLogs", "", strconv.Itoa(int(before.UnixMicro())))
+	if err != nil {
+		return
+	}
+
+	log.Info().Str("purged", string(data)).Time("before", before).Msg("purged access logs")
+}
+
+func startLogPurger() {
+	TestEnv("OBC_LOG_RETENTION", &logRetention)
+
+	if logRetention == "" {
+		return
+	}
+
+	retention, err := time.ParseDuration(logRetention)
+	if err != nil {
+		panic(err)
+	}
+
+	go func() {
+		purgeAccessLogs(retention)
+		for range time.Tick(time.Hour) {
+			purgeAccessLogs(retention)
+		}
+	}()
+}
+
+// parseTimeQuery turns an optional RFC3339 query value into microseconds.
+func parseTimeQuery(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(t.UnixMicro()), nil
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Inventory is the desired state of an org's resources as kept in an apply
+// file. Resources are referred to by their external id everywhere, apply
+// only manages resources that have one.
+type Inventory struct {
+	Resources []ManifestRow      `yaml:"resources"`
+	Pools     []InventoryPool    `yaml:"pools"`
+	Grants    []InventoryGrant   `yaml:"grants"`
+	Listings  []InventoryListing `yaml:"listings"`
+}
+
+type InventoryPool struct {
+	Name      string   `yaml:"name"`
+	Resources []string `yaml:"resources"`
+}
+
+// InventoryGrant gives a user access to resources. Expiry is RFC3339 or a
+// duration from the time the grant is made, empty for no expiry.
+type InventoryGrant struct {
+	User      string   `yaml:"user"`
+	Resources []string `yaml:"resources"`
+	Pool      string   `yaml:"pool"`
+	Expiry    string   `yaml:"expiry"`
+}
+
+type InventoryListing struct {
+	Resource string `yaml:"resource"`
+	Duration string `yaml:"duration"`
+	Price    int    `yaml:"price"`
+}
+
+type applyResource struct {
+	Id         string            `json:"Id"`
+	Name       string            `json:"Name"`
+	Labels     map[string]string `json:"Labels"`
+	State      string            `json:"State"`
+	ExternalId string            `json:"ExternalId"`
+}
+
+type applyPool struct {
+	Name      string   `json:"Name"`
+	Resources []string `json:"Resources"`
+}
+
+type applyUser struct {
+	UserName       string         `json:"UserName"`
+	ComputeResList []string       `json:"ComputeResList"`
+	GrantExpiry    map[string]int `json:"GrantExpiry"`
+}
+
+type applyListing struct {
+	Id       string `json:"id"`
+	Price    int    `json:"price"`
+	Duration int    `json:"duration"`
+	OwnerOrg string `json:"ownerOrg"`
+	Res      struct {
+		Id string `json:"Id"`
+	} `json:"resource"`
+	Units struct {
+		Gpus   int `json:"gpus"`
+		Cores  int `json:"cores"`
+		Memory int `json:"memory"`
+	} `json:"units"`
+}
+
+// applyStep is one change of a plan. Op is "+" to create, "~" to update and
+// "-" to delete.
+type applyStep struct {
+	Op     string
+	Kind   string
+	Name   string
+	Detail string
+	run    func() error
+}
+
+func (s applyStep) String() string {
+	line := fmt.Sprintf("%s %s %s", s.Op, s.Kind, s.Name)
+	if s.Detail != "" {
+		line += " (" + s.Detail + ")"
+	}
+	return line
+}
+
+// applyState is what the ledger holds for the org.
+type applyState struct {
+	resources map[string]applyResource // by external id
+	pools     map[string]applyPool
+	users     map[string]applyUser
+	listings  map[string]applyListing // open whole machine listings by resource id
+}
+
+func queryJSON(v any, fn string, args ...string) error {
+	data, err := Query(fn, args...)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func loadApplyState() (*applyState, error) {
+	st := &applyState{
+		resources: map[string]applyResource{},
+		pools:     map[string]applyPool{},
+		users:     map[string]applyUser{},
+		listings:  map[string]applyListing{},
+	}
+
+	var resources []applyResource
+	if err := queryJSON(&resources, "ListOwnedComputeRes"); err != nil {
+		return nil, err
+	}
+	for _, r := range resources {
+		if r.ExternalId != "" && r.State != "decommissioned" {
+			st.resources[r.ExternalId] = r
+		}
+	}
+
+	var pools []applyPool
+	if err := queryJSON(&pools, "ListPools"); err != nil {
+		return nil, err
+	}
+	for _, p := range pools {
+		st.pools[p.Name] = p
+	}
+
+	var users []applyUser
+	if err := queryJSON(&users, "ListUsers"); err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		st.users[u.UserName] = u
+	}
+
+	var listings []applyListing
+	if err := queryJSON(&listings, "ListMarketElementsByStatus", "open"); err != nil {
+		return nil, err
+	}
+	for _, l := range listings {
+		if l.OwnerOrg == mspID && l.Units.Gpus == 0 && l.Units.Cores == 0 && l.Units.Memory == 0 {
+			st.listings[l.Res.Id] = l
+		}
+	}
+
+	return st, nil
+}
+
+// planApply diffs the inventory against the ledger. Steps run in order, so
+// resources are created before anything refers to them and removed after
+// everything that referred to them.
+func planApply(inv *Inventory, st *applyState, prune bool) ([]applyStep, error) {
+	ids := map[string]string{}
+	for ext, r := range st.resources {
+		ids[ext] = r.Id
+	}
+
+	// resolve looks up ids when the step runs, after new resources exist
+	resolve := func(exts []string) ([]string, error) {
+		res := []string{}
+		for _, ext := range exts {
+			id, ok := ids[ext]
+			if !ok {
+				return nil, fmt.Errorf("the resource %s was not created", ext)
+			}
+			res = append(res, id)
+		}
+		return res, nil
+	}
+
+	known := map[string]bool{}
+	for _, r := range inv.Resources {
+		if r.ExternalId == "" {
+			return nil, fmt.Errorf("a resource has no external_id")
+		}
+		if known[r.ExternalId] {
+			return nil, fmt.Errorf("duplicate resource %s", r.ExternalId)
+		}
+		known[r.ExternalId] = true
+	}
+
+	poolMembers := map[string][]string{}
+	for _, p := range inv.Pools {
+		for _, ext := range p.Resources {
+			if !known[ext] {
+				return nil, fmt.Errorf("the pool %s refers to the unknown resource %s", p.Name, ext)
+			}
+		}
+		poolMembers[p.Name] = p.Resources
+	}
+
+	var steps, removals []applyStep
+
+	// resources
+	var rows []ManifestRow
+	var rowSteps []applyStep
+	for _, r := range inv.Resources {
+		cur, ok := st.resources[r.ExternalId]
+		if !ok {
+			rows = append(rows, r)
+			rowSteps = append(rowSteps, applyStep{Op: "+", Kind: "resource", Name: r.ExternalId, Detail: r.Name})
+			continue
+		}
+
+		var changed []string
+		if r.Name != "" && r.Name != cur.Name {
+			changed = append(changed, "name")
+		}
+		if r.Labels != nil && !maps.Equal(r.Labels, cur.Labels) {
+			changed = append(changed, "labels")
+		}
+		if len(changed) > 0 {
+			// credentials are only set when a resource is created
+			r.SSH = nil
+			rows = append(rows, r)
+			rowSteps = append(rowSteps, applyStep{Op: "~", Kind: "resource", Name: r.ExternalId, Detail: strings.Join(changed, ", ")})
+		}
+	}
+
+	if len(rows) > 0 {
+		// one import covers every created and updated resource
+		var results map[string]ImportResult
+		var importErr error
+		importRows := func() error {
+			if results != nil || importErr != nil {
+				return importErr
+			}
+
+			res, err := importManifest(rows)
+			if err != nil {
+				importErr = err
+				return err
+			}
+
+			results = map[string]ImportResult{}
+			for _, r := range res {
+				results[r.ExternalId] = r
+				if r.Id != "" && r.Status != "failed" {
+					ids[r.ExternalId] = r.Id
+				}
+			}
+			return nil
+		}
+
+		for _, s := range rowSteps {
+			ext := s.Name
+			s.run = func() error {
+				if err := importRows(); err != nil {
+					return err
+				}
+				r, ok := results[ext]
+				if !ok {
+					return fmt.Errorf("no import result")
+				}
+				if r.Status == "failed" {
+					return fmt.Errorf("%s", r.Error)
+				}
+				return nil
+			}
+			steps = append(steps, s)
+		}
+	}
+
+	if prune {
+		for _, ext := range sortedKeys(st.resources) {
+			if known[ext] {
+				continue
+			}
+			id := st.resources[ext].Id
+			removals = append(removals, applyStep{Op: "-", Kind: "resource", Name: ext, run: func() error {
+				_, err := Invoke("DelComputeRes", id)
+				return err
+			}})
+		}
+	}
+
+	// pools
+	for _, p := range inv.Pools {
+		cur, ok := st.pools[p.Name]
+		if ok {
+			want, err := resolve(p.Resources)
+			if err == nil && slices.Equal(sortedCopy(want), sortedCopy(cur.Resources)) {
+				continue
+			}
+		}
+
+		op := "+"
+		if ok {
+			op = "~"
+		}
+
+		name, members := p.Name, p.Resources
+		steps = append(steps, applyStep{Op: op, Kind: "pool", Name: name, Detail: strings.Join(members, ", "), run: func() error {
+			want, err := resolve(members)
+			if err != nil {
+				return err
+			}
+			_d, err := json.Marshal(want)
+			if err != nil {
+				return err
+			}
+			_, err = Invoke("SetPool", name, string(_d))
+			return err
+		}})
+	}
+
+	if prune {
+		for _, name := range sortedKeys(st.pools) {
+			if _, ok := poolMembers[name]; ok {
+				continue
+			}
+			removals = append(removals, applyStep{Op: "-", Kind: "pool", Name: name, run: func() error {
+				_, err := Invoke("DeletePool", name)
+				return err
+			}})
+		}
+	}
+
+	// grants, on managed resources only
+	wanted := map[string]map[string]bool{}
+	for _, g := range inv.Grants {
+		exts := slices.Clone(g.Resources)
+		if g.Pool != "" {
+			members, ok := poolMembers[g.Pool]
+			if !ok {
+				return nil, fmt.Errorf("the grant for %s refers to the unknown pool %s", g.User, g.Pool)
+			}
+			exts = append(exts, members...)
+		}
+
+		if wanted[g.User] == nil {
+			wanted[g.User] = map[string]bool{}
+		}
+
+		for _, ext := range exts {
+			if !known[ext] {
+				return nil, fmt.Errorf("the grant for %s refers to the unknown resource %s", g.User, ext)
+			}
+			if wanted[g.User][ext] {
+				continue
+			}
+			wanted[g.User][ext] = true
+
+			u := st.users[g.User]
+			if id, ok := ids[ext]; ok && slices.Contains(u.ComputeResList, id) {
+				if g.Expiry == "" && u.GrantExpiry[id] == 0 {
+					continue
+				}
+				// a duration is measured from the first apply
+				if _, err := time.ParseDuration(g.Expiry); err == nil {
+					continue
+				}
+				if e, err := time.Parse(time.RFC3339, g.Expiry); err == nil && int(e.UnixMicro()) == u.GrantExpiry[id] {
+					continue
+				}
+			}
+
+			user, ext, expiry := g.User, ext, g.Expiry
+			steps = append(steps, applyStep{Op: "+", Kind: "grant", Name: user + " " + ext, Detail: expiry, run: func() error {
+				id, err := resolve([]string{ext})
+				if err != nil {
+					return err
+				}
+				e, err := parseInventoryExpiry(expiry)
+				if err != nil {
+					return err
+				}
+				_, err = Invoke("GrantAccess", user, id[0], strconv.Itoa(e))
+				return err
+			}})
+		}
+	}
+
+	if prune {
+		for _, name := range sortedKeys(st.users) {
+			for _, ext := range sortedKeys(st.resources) {
+				id := st.resources[ext].Id
+				if !slices.Contains(st.users[name].ComputeResList, id) || wanted[name][ext] {
+					continue
+				}
+				user := name
+				removals = append(removals, applyStep{Op: "-", Kind: "grant", Name: user + " " + ext, run: func() error {
+					_, err := Invoke("RevokeAccess", user, id)
+					return err
+				}})
+			}
+		}
+	}
+
+	// listings, whole machines only
+	listed := map[string]bool{}
+	for _, l := range inv.Listings {
+		if !known[l.Resource] {
+			return nil, fmt.Errorf("the listing refers to the unknown resource %s", l.Resource)
+		}
+		if listed[l.Resource] {
+			return nil, fmt.Errorf("the resource %s is listed twice", l.Resource)
+		}
+		listed[l.Resource] = true
+
+		d, err := time.ParseDuration(l.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration of the listing of %s: %w", l.Resource, err)
+		}
+		duration := int(d.Microseconds())
+
+		var cur *applyListing
+		if id, ok := ids[l.Resource]; ok {
+			if c, ok := st.listings[id]; ok {
+				cur = &c
+			}
+		}
+
+		if cur != nil && cur.Price == l.Price && cur.Duration == duration {
+			continue
+		}
+
+		op := "+"
+		if cur != nil {
+			op = "~"
+		}
+
+		ext, price := l.Resource, l.Price
+		steps = append(steps, applyStep{Op: op, Kind: "listing", Name: ext, Detail: fmt.Sprintf("%s for %d", l.Duration, price), run: func() error {
+			if cur != nil {
+				if _, err := Invoke("RemoveFromMarket", cur.Id); err != nil {
+					return err
+				}
+			}
+			id, err := resolve([]string{ext})
+			if err != nil {
+				return err
+			}
+			_, err = Invoke("PutOnMarket", id[0], strconv.Itoa(duration), strconv.Itoa(price))
+			return err
+		}})
+	}
+
+	if prune {
+		for _, ext := range sortedKeys(st.resources) {
+			l, ok := st.listings[st.resources[ext].Id]
+			if !ok || listed[ext] {
+				continue
+			}
+			removals = append(removals, applyStep{Op: "-", Kind: "listing", Name: ext, run: func() error {
+				_, err := Invoke("RemoveFromMarket", l.Id)
+				return err
+			}})
+		}
+	}
+
+	// remove listings before grants, pools and finally the resources
+	sort.SliceStable(removals, func(i, j int) bool {
+		order := map[string]int{"listing": 0, "grant": 1, "pool": 2, "resource": 3}
+		return order[removals[i].Kind] < order[removals[j].Kind]
+	})
+
+	return append(steps, removals...), nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedCopy(s []string) []string {
+	c := slices.Clone(s)
+	sort.Strings(c)
+	return c
+}
+
+func parseInventoryExpiry(expiry string) (int, error) {
+	if _, err := time.ParseDuration(expiry); err == nil {
+		return parseExpiry(map[string]string{"duration": expiry})
+	}
+	return parseExpiry(map[string]string{"expiry": expiry})
+}
+
+// runApply converges the org's inventory to an apply file:
+//
+//	OpenBlockComputed apply -f inventory.yaml [-dry-run] [-prune]
+//
+// Without -prune nothing is removed from the ledger.
+func runApply(args []string) int {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	file := fs.String("f", "inventory.yaml", "the inventory file to apply")
+	dryRun := fs.Bool("dry-run", false, "only print the plan")
+	prune := fs.Bool("prune", false, "remove managed resources, pools, grants and listings missing from the file")
+	fs.Parse(args)
+
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var inv Inventory
+	if err := yaml.Unmarshal(data, &inv); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	st, err := loadApplyState()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	steps, err := planApply(&inv, st, *prune)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if len(steps) == 0 {
+		fmt.Println("nothing to do")
+		return 0
+	}
+
+	if *dryRun {
+		for _, s := range steps {
+			fmt.Println(s)
+		}
+		fmt.Printf("%d changes\n", len(steps))
+		return 0
+	}
+
+	failed := 0
+	for _, s := range steps {
+		if err := s.run(); err != nil {
+			failed++
+			fmt.Printf("%s: %v\n", s, err)
+			continue
+		}
+		fmt.Printf("%s: done\n", s)
+	}
+
+	fmt.Printf("%d changes, %d failed\n", len(steps), failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// AccessRecord is one connection to a resource. Records are kept per
+// accessing org under the range key (org, resource, time, tx id), so an org
+// only ever reads its own users' accesses and reads them by time.
+type AccessRecord struct {
+	Res        string `json:"Res"`
+	Org        string `json:"Org"`
+	Lease      string `json:"Lease"`
+	AccessTime int    `json:"AccessTime"`
+	AccessUser string `json:"AccessUser"`
+	TxId       string `json:"TxId"`
+}
+
+type AccessLogPage struct {
+	Logs     []*AccessRecord `json:"Logs"`
+	Bookmark string          `json:"Bookmark"`
+}
+
+func (s *SmartContract) putAccessRecord(ctx contractapi.TransactionContextInterface, r *AccessRecord) error {
+	key, err := rangeKey(ctx, accessKeyType, r.Org, r.Res, fmt.Sprintf("%020d", r.AccessTime), r.TxId)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(*r)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutPrivateData(assetAccessLogs, key, data)
+}
+
+// GetConnectionLogs returns the org's accesses to a resource between from and
+// to (microseconds, 0 for no bound) in time order, one page at a time. Pass
+// the returned bookmark to get the next page.
+func (s *SmartContract) GetConnectionLogs(ctx contractapi.TransactionContextInterface, id string, from int, to int, pageSize int, bookmark string) (AccessLogPage, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return AccessLogPage{}, err
+	}
+
+	if _, err := s.viewComputeRes(ctx, id); err != nil {
+		return AccessLogPage{}, err
+	}
+
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	prefix, err := rangeKey(ctx, accessKeyType, org, id)
+	if err != nil {
+		return AccessLogPage{}, err
+	}
+
+	start, end, err := timeRange(prefix, from, to, bookmark)
+	if err != nil {
+		return AccessLogPage{}, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByRange(assetAccessLogs, start, end)
+	if err != nil {
+		return AccessLogPage{}, err
+	}
+	defer resultsIterator.Close()
+
+	page := AccessLogPage{Logs: []*AccessRecord{}}
+	var after string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return AccessLogPage{}, err
+		}
+
+		if len(page.Logs) == pageSize {
+			page.Bookmark = base64.RawURLEncoding.EncodeToString([]byte(after))
+			break
+		}
+
+		var r AccessRecord
+		err = json.Unmarshal(queryResponse.Value, &r)
+		if err != nil {
+			return AccessLogPage{}, err
+		}
+
+		page.Logs = append(page.Logs, &r)
+		after = queryResponse.Key
+	}
+
+	return page, nil
+}
+
+// PurgeConnectionLogs deletes the org's access records older than before,
+// for one resource or, with an empty id, for all resources.
+func (s *SmartContract) PurgeConnectionLogs(ctx contractapi.TransactionContextInterface, id string, before int) (int, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.purgeAccessRecords(ctx, org, id, before)
+}
+
+// purgeAccessRecords deletes org's access records older than before, all of
+// them when before is 0.
+func (s *SmartContract) purgeAccessRecords(ctx contractapi.TransactionContextInterface, org string, id string, before int) (int, error) {
+	attrs := []string{org}
+	if id != "" {
+		attrs = append(attrs, id)
+	}
+
+	prefix, err := rangeKey(ctx, accessKeyType, attrs...)
+	if err != nil {
+		return 0, err
+	}
+
+	// the records of one resource are in time order
+	end := prefix + string(utf8.MaxRune)
+	if id != "" && before != 0 {
+		end = prefix + fmt.Sprintf("%020d", before)
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByRange(assetAccessLogs, prefix, end)
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	purged := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return purged, err
+		}
+
+		var r AccessRecord
+		err = json.Unmarshal(queryResponse.Value, &r)
+		if err != nil {
+			return purged, err
+		}
+
+		if before != 0 && r.AccessTime >= before {
+			continue
+		}
+
+		err = ctx.GetStub().DelPrivateData(assetAccessLogs, queryResponse.Key)
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// MigrateAccessLogs moves the access logs older versions kept inside the
+// resources the org uses into access records, and the org's records kept
+// under composite keys to range keys.
+func (s *SmartContract) MigrateAccessLogs(ctx contractapi.TransactionContextInterface) (int, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	migrated, err := s.rekeyAccessRecords(ctx, org)
+	if err != nil {
+		return migrated, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByRange(assetComputeRes, "", "")
+	if err != nil {
+		return migrated, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return migrated, err
+		}
+
+		var asset ComputeRes
+		err = json.Unmarshal(queryResponse.Value, &asset)
+		if err != nil {
+			return migrated, err
+		}
+
+		if asset.UserOrg != org || len(asset.AccessLogs) == 0 {
+			continue
+		}
+
+		for i, a := range asset.AccessLogs {
+			err = s.putAccessRecord(ctx, &AccessRecord{
+				Res:        asset.Id,
+				Org:        org,
+				AccessTime: a.AccessTime,
+				AccessUser: a.AccessUser,
+				TxId:       fmt.Sprintf("legacy-%d", i),
+			})
+			if err != nil {
+				return migrated, err
+			}
+			migrated++
+		}
+
+		asset.AccessLogs = nil
+
+		err = s.putComputeRes(ctx, asset.Id, &asset)
+		if err != nil {
+			return migrated, err
+		}
+	}
+
+	return migrated, nil
+}
+
+// rekeyAccessRecords moves org's access records kept under composite keys,
+// which can't be read from a bookmark on, to range keys.
+func (s *SmartContract) rekeyAccessRecords(ctx contractapi.TransactionContextInterface, org string) (int, error) {
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(assetAccessLogs, accessKeyType, []string{org})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	moved := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return moved, err
+		}
+
+		var r AccessRecord
+		err = json.Unmarshal(queryResponse.Value, &r)
+		if err != nil {
+			return moved, err
+		}
+
+		err = s.putAccessRecord(ctx, &r)
+		if err != nil {
+			return moved, err
+		}
+
+		err = ctx.GetStub().DelPrivateData(assetAccessLogs, queryResponse.Key)
+		if err != nil {
+			return moved, err
+		}
+		moved++
+	}
+
+	return moved, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const (
+	// attrRoleName is the Fabric CA certificate attribute that overrides the
+	// on-ledger User.Role, e.g. obc.role=operator.
+	attrRoleName = "obc.role"
+)
+
+// policyPermissions are the permissions an AccessPolicy may hand out.
+var policyPermissions = []string{permConnect, permLogsRead, permResUpdate}
+
+// txResourceArg maps resource scoped transactions to the index of their
+// resource id argument, so the permission hook can consult AccessPolicies.
+var txResourceArg = map[string]int{
+	"GetConnectDetails": 0,
+	"GetConnectionLogs": 0,
+	"UpdateComputeRes":  0,
+}
+
+// AccessPolicy grants a permission on one resource to every client of Org
+// whose certificate carries Attr=Value, e.g. obc.team=ml may connect.
+type AccessPolicy struct {
+	Org        string `json:"org"`
+	Attr       string `json:"attr"`
+	Value      string `json:"value"`
+	Permission string `json:"permission"`
+}
+
+func (c *ComputeRes) policyAllows(ctx contractapi.TransactionContextInterface, org string, perm string) bool {
+	for _, p := range c.AccessPolicies {
+		if p.Org != org || p.Permission != perm {
+			continue
+		}
+
+		val, ok, err := ctx.GetClientIdentity().GetAttributeValue(p.Attr)
+		if err == nil && ok && val == p.Value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *SmartContract) policyAllows(ctx contractapi.TransactionContextInterface, org string, id string, perm string) bool {
+	state, err := s.readState(ctx, assetComputeRes, id)
+	if err != nil {
+		return false
+	}
+
+	var res ComputeRes
+	if json.Unmarshal(state, &res) != nil {
+		return false
+	}
+
+	if res.UserOrg != org && res.OwnerOrg != org {
+		return false
+	}
+
+	return res.policyAllows(ctx, org, perm)
+}
+
+// SetAccessPolicies replaces the owner's attribute policies on a resource.
+// Policies set by other orgs are kept.
+func (s *SmartContract) SetAccessPolicies(ctx contractapi.TransactionContextInterface, id string, policies []AccessPolicy) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the whole record is written back, so it is read unredacted
+	asset, err := s.readComputeRes(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if !asset.visibleTo(org) {
+		return fmt.Errorf("unauthorized access")
+	}
+
+	if asset.OwnerOrg != org {
+		return fmt.Errorf("only the owner can set the access policies of %s", id)
+	}
+
+	kept := []AccessPolicy{}
+	for _, p := range asset.AccessPolicies {
+		if p.Org != org {
+			kept = append(kept, p)
+		}
+	}
+
+	for _, p := range policies {
+		if p.Attr == "" || p.Value == "" {
+			return fmt.Errorf("policy attribute and value can't be empty")
+		}
+
+		if !contains(p.Permission, policyPermissions) {
+			return fmt.Errorf("permission %s can't be granted by an attribute policy", p.Permission)
+		}
+
+		p.Org = org
+		kept = append(kept, p)
+	}
+
+	asset.AccessPolicies = kept
+
+	return s.putComputeRes(ctx, id, asset)
+}
+
+func (s *SmartContract) GetAccessPolicies(ctx contractapi.TransactionContextInterface, id string) ([]AccessPolicy, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	asset, err := s.viewComputeRes(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := []AccessPolicy{}
+	for _, p := range asset.AccessPolicies {
+		if p.Org == org {
+			policies = append(policies, p)
+		}
+	}
+
+	return policies, nil
+}
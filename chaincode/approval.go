@@ -0,0 +1,451 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const (
+	proposalPending   = "pending"
+	proposalExecuted  = "executed"
+	proposalCancelled = "cancelled"
+	proposalExpired   = "expired"
+
+	// defaultProposalTTL applies when a proposal is created without a ttl.
+	defaultProposalTTL = 7 * 24 * time.Hour
+)
+
+// approvalOperations are the transactions an org may put behind M-of-N
+// approval, together with the executor run once a proposal is approved.
+var approvalOperations = map[string]func(s *SmartContract, ctx contractapi.TransactionContextInterface, org string, args []string) error{
+	"DelComputeRes": func(s *SmartContract, ctx contractapi.TransactionContextInterface, org string, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("DelComputeRes expects 1 argument, got %d", len(args))
+		}
+		return s.delComputeRes(ctx, org, args[0])
+	},
+	"LockMarketElement": func(s *SmartContract, ctx contractapi.TransactionContextInterface, org string, args []string) error {
+		if len(args) != 3 {
+			return fmt.Errorf("LockMarketElement expects 3 arguments, got %d", len(args))
+		}
+		price, err := strconv.Atoi(args[2])
+		if err != nil {
+			return fmt.Errorf("invalid price %s: %v", args[2], err)
+		}
+		return s.lockMarketElement(ctx, org, args[0], args[1], price)
+	},
+	"EndMarketElement": func(s *SmartContract, ctx contractapi.TransactionContextInterface, org string, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("EndMarketElement expects 1 argument, got %d", len(args))
+		}
+		return s.endMarketElement(ctx, org, args[0])
+	},
+	"SettleMarketElement": func(s *SmartContract, ctx contractapi.TransactionContextInterface, org string, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("SettleMarketElement expects 1 argument, got %d", len(args))
+		}
+		return s.settleMarketElement(ctx, org, args[0])
+	},
+}
+
+// changes of the policy are checked against approvalOperations, so they are
+// registered once it is initialized
+func init() {
+	approvalOperations["SetApprovalPolicy"] = func(s *SmartContract, ctx contractapi.TransactionContextInterface, org string, args []string) error {
+		if len(args) != 2 {
+			return fmt.Errorf("SetApprovalPolicy expects 2 arguments, got %d", len(args))
+		}
+		threshold, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid threshold %s: %v", args[0], err)
+		}
+		var operations []string
+		err = json.Unmarshal([]byte(args[1]), &operations)
+		if err != nil {
+			return fmt.Errorf("invalid operations %s: %v", args[1], err)
+		}
+		return s.setApprovalPolicy(ctx, org, threshold, operations)
+	}
+}
+
+type ApprovalPolicy struct {
+	Org        string   `json:"Org"`
+	Threshold  int      `json:"Threshold"`
+	Operations []string `json:"Operations"`
+}
+
+type Proposal struct {
+	Id       string   `json:"Id"`
+	Org      string   `json:"Org"`
+	Function string   `json:"Function"`
+	Args     []string `json:"Args"`
+
+	Proposer  string   `json:"Proposer"`
+	Approvals []string `json:"Approvals"`
+	Threshold int      `json:"Threshold"`
+
+	Status  string `json:"Status"`
+	Created int    `json:"Created"`
+	Expiry  int    `json:"Expiry"`
+}
+
+func (s *SmartContract) getApprovalPolicy(ctx contractapi.TransactionContextInterface, org string) (*ApprovalPolicy, error) {
+	pid, err := ctx.GetStub().CreateCompositeKey(approvalPolicyKeyType, []string{org})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	state, err := ctx.GetStub().GetPrivateData(assetUser, pid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %w", err)
+	}
+
+	policy := ApprovalPolicy{Org: org, Threshold: 1, Operations: []string{}}
+	if state != nil {
+		err = json.Unmarshal(state, &policy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &policy, nil
+}
+
+// requires reports whether function needs approval under the policy. Changing
+// the policy itself always does, whether or not it is listed, so that no
+// single user can lower the threshold.
+func (p *ApprovalPolicy) requires(function string) bool {
+	if p.Threshold <= 1 {
+		return false
+	}
+
+	return function == "SetApprovalPolicy" || contains(function, p.Operations)
+}
+
+// requireNoApproval fails when the org has put function behind M-of-N approval,
+// in which case it has to go through ProposeOperation instead.
+func (s *SmartContract) requireNoApproval(ctx contractapi.TransactionContextInterface, org string, function string) error {
+	policy, err := s.getApprovalPolicy(ctx, org)
+	if err != nil {
+		return err
+	}
+
+	if policy.requires(function) {
+		return fmt.Errorf("%s requires approval by %d users of %s, submit it with ProposeOperation", function, policy.Threshold, org)
+	}
+
+	return nil
+}
+
+// SetApprovalPolicy replaces the org's approval policy. Once the policy asks
+// for more than one approval, changing it has to be approved under it.
+func (s *SmartContract) SetApprovalPolicy(ctx contractapi.TransactionContextInterface, threshold int, operations []string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.requireNoApproval(ctx, org, "SetApprovalPolicy"); err != nil {
+		return err
+	}
+
+	return s.setApprovalPolicy(ctx, org, threshold, operations)
+}
+
+func (s *SmartContract) setApprovalPolicy(ctx contractapi.TransactionContextInterface, org string, threshold int, operations []string) error {
+	if threshold < 1 {
+		return fmt.Errorf("the threshold must be at least 1")
+	}
+
+	for _, op := range operations {
+		if _, ok := approvalOperations[op]; !ok {
+			return fmt.Errorf("%s can't be put behind approval", op)
+		}
+	}
+
+	if operations == nil {
+		operations = []string{}
+	}
+
+	pid, err := ctx.GetStub().CreateCompositeKey(approvalPolicyKeyType, []string{org})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	data, err := json.Marshal(ApprovalPolicy{Org: org, Threshold: threshold, Operations: operations})
+	if err != nil {
+		return err
+	}
+
+	return s.putState(ctx, assetUser, pid, data)
+}
+
+func (s *SmartContract) GetApprovalPolicy(ctx contractapi.TransactionContextInterface) (ApprovalPolicy, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return ApprovalPolicy{}, err
+	}
+
+	policy, err := s.getApprovalPolicy(ctx, org)
+	if err != nil {
+		return ApprovalPolicy{}, err
+	}
+
+	return *policy, nil
+}
+
+func (s *SmartContract) getProposal(ctx contractapi.TransactionContextInterface, org string, id string) (string, *Proposal, error) {
+	pid, err := ctx.GetStub().CreateCompositeKey(proposalKeyType, []string{org, id})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	state, err := s.readState(ctx, assetUser, pid)
+	if err != nil {
+		return pid, nil, err
+	}
+
+	var p Proposal
+	err = json.Unmarshal(state, &p)
+	if err != nil {
+		return pid, nil, err
+	}
+
+	return pid, &p, nil
+}
+
+func (s *SmartContract) putProposal(ctx contractapi.TransactionContextInterface, pid string, p *Proposal) error {
+	data, err := json.Marshal(*p)
+	if err != nil {
+		return err
+	}
+
+	return s.putState(ctx, assetUser, pid, data)
+}
+
+// authorizeApprover checks that the submitting user may approve operations in
+// the org and returns the client identity its approval is counted under, so
+// that one identity can't approve twice under different user names.
+func (s *SmartContract) authorizeApprover(ctx contractapi.TransactionContextInterface, org string, function string) (string, error) {
+	usr, err := s.getUserInfo(ctx, org)
+	if err != nil {
+		return "", fmt.Errorf("failed to get user info: %v", err)
+	}
+
+	if !s.hasPermission(ctx, org, usr, permApprove) {
+		return "", fmt.Errorf("user %s (role %s) is not authorized to approve %s: missing permission %s", usr.UserName, usr.Role, function, permApprove)
+	}
+
+	return s.GetSubmittingClientIdentity(ctx)
+}
+
+func (s *SmartContract) ProposeOperation(ctx contractapi.TransactionContextInterface, function string, args []string, ttl int) (string, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if _, ok := approvalOperations[function]; !ok {
+		return "", fmt.Errorf("%s can't be proposed", function)
+	}
+
+	policy, err := s.getApprovalPolicy(ctx, org)
+	if err != nil {
+		return "", err
+	}
+
+	if !policy.requires(function) {
+		return "", fmt.Errorf("%s does not require approval in %s", function, org)
+	}
+
+	approver, err := s.authorizeApprover(ctx, org, function)
+	if err != nil {
+		return "", err
+	}
+
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return "", err
+	}
+
+	if ttl <= 0 {
+		ttl = int(defaultProposalTTL.Microseconds())
+	}
+
+	if args == nil {
+		args = []string{}
+	}
+
+	id := ctx.GetStub().GetTxID()
+	now := int(_time.AsTime().UnixMicro())
+
+	pid, _, err := s.getProposal(ctx, org, id)
+	if err == nil {
+		return "", fmt.Errorf("the proposal %s already exists", id)
+	}
+
+	p := Proposal{
+		Id:       id,
+		Org:      org,
+		Function: function,
+		Args:     args,
+
+		Proposer:  approver,
+		Approvals: []string{approver},
+		Threshold: policy.Threshold,
+
+		Status:  proposalPending,
+		Created: now,
+		Expiry:  now + ttl,
+	}
+
+	return id, s.putProposal(ctx, pid, &p)
+}
+
+// ApproveOperation adds the submitting user's approval and runs the operation
+// in the same transaction once the threshold is reached.
+func (s *SmartContract) ApproveOperation(ctx contractapi.TransactionContextInterface, id string) (Proposal, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return Proposal{}, err
+	}
+
+	pid, p, err := s.getProposal(ctx, org, id)
+	if err != nil {
+		return Proposal{}, err
+	}
+
+	if p.Status != proposalPending {
+		return Proposal{}, fmt.Errorf("the proposal is %s", p.Status)
+	}
+
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return Proposal{}, err
+	}
+
+	if int(_time.AsTime().UnixMicro()) >= p.Expiry {
+		return Proposal{}, fmt.Errorf("the proposal has expired")
+	}
+
+	approver, err := s.authorizeApprover(ctx, org, p.Function)
+	if err != nil {
+		return Proposal{}, err
+	}
+
+	if contains(approver, p.Approvals) {
+		return Proposal{}, fmt.Errorf("%s already approved this proposal", approver)
+	}
+
+	p.Approvals = append(p.Approvals, approver)
+
+	if len(p.Approvals) >= p.Threshold {
+		err = approvalOperations[p.Function](s, ctx, org, p.Args)
+		if err != nil {
+			return Proposal{}, fmt.Errorf("failed to execute %s: %v", p.Function, err)
+		}
+		p.Status = proposalExecuted
+	}
+
+	return *p, s.putProposal(ctx, pid, p)
+}
+
+func (s *SmartContract) CancelOperation(ctx contractapi.TransactionContextInterface, id string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	pid, p, err := s.getProposal(ctx, org, id)
+	if err != nil {
+		return err
+	}
+
+	if p.Status != proposalPending {
+		return fmt.Errorf("the proposal is %s", p.Status)
+	}
+
+	usr, err := s.getUserInfo(ctx, org)
+	if err != nil {
+		return fmt.Errorf("failed to get user info: %v", err)
+	}
+
+	identity, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return err
+	}
+
+	if identity != p.Proposer && !s.hasPermission(ctx, org, usr, permRoleManage) {
+		return fmt.Errorf("only the proposer can cancel this proposal")
+	}
+
+	p.Status = proposalCancelled
+
+	return s.putProposal(ctx, pid, p)
+}
+
+func (s *SmartContract) GetOperation(ctx contractapi.TransactionContextInterface, id string) (Proposal, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return Proposal{}, err
+	}
+
+	_, p, err := s.getProposal(ctx, org, id)
+	if err != nil {
+		return Proposal{}, err
+	}
+
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return Proposal{}, err
+	}
+
+	if p.Status == proposalPending && int(_time.AsTime().UnixMicro()) >= p.Expiry {
+		p.Status = proposalExpired
+	}
+
+	return *p, nil
+}
+
+func (s *SmartContract) ListPendingOperations(ctx contractapi.TransactionContextInterface) ([]*Proposal, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	now := int(_time.AsTime().UnixMicro())
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(assetUser, proposalKeyType, []string{org})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	proposals := []*Proposal{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var p Proposal
+		err = json.Unmarshal(queryResponse.Value, &p)
+		if err != nil {
+			return nil, err
+		}
+
+		if p.Status == proposalPending && now < p.Expiry {
+			proposals = append(proposals, &p)
+		}
+	}
+
+	return proposals, nil
+}
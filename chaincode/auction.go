@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// Selection policies of a market element. Elements without a policy are
+// left to the owner.
+const (
+	policyHighest  = "highest"
+	policyEarliest = "earliest"
+	policyOwner    = "owner"
+)
+
+var auctionPolicies = []string{policyHighest, policyEarliest, policyOwner}
+
+// SetAuctionTerms sets when bidding on an open element closes, in
+// microseconds since the epoch or 0 for never, and how its winner is picked:
+// the highest bid, the earliest bid at or above the asking price, or by the
+// owner with LockMarketElement. The terms can't change once there are bids.
+func (s *SmartContract) SetAuctionTerms(ctx contractapi.TransactionContextInterface, id string, closeTime int, policy string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.getResMarketElement(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if res.OwnerOrg != org {
+		return fmt.Errorf("only the releaser can set the terms of a market element")
+	}
+
+	if res.Status != "open" {
+		return fmt.Errorf("the market status can't be modified %s", res.Status)
+	}
+
+	if res.MarketType == marketFixed {
+		return fmt.Errorf("the element %s has a fixed price", id)
+	}
+
+	if len(res.Buyers) > 0 || len(res.Commitments) > 0 {
+		return fmt.Errorf("the terms of %s can't change once bids are made", id)
+	}
+
+	if policy == "" {
+		policy = policyOwner
+	}
+
+	if !contains(policy, auctionPolicies) {
+		return fmt.Errorf("unknown selection policy %s", policy)
+	}
+
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+
+	if closeTime != 0 && closeTime <= int(_time.AsTime().UnixMicro()) {
+		return fmt.Errorf("the close time must be in the future")
+	}
+
+	if closeTime == 0 && (policy != policyOwner || res.Sealed) {
+		return fmt.Errorf("the element %s needs a close time", id)
+	}
+
+	if res.Sealed && closeTime >= res.RevealTime {
+		return fmt.Errorf("the close time must be before the reveal time")
+	}
+
+	res.CloseTime = closeTime
+	res.Policy = policy
+
+	return s.putResMarketElement(ctx, id, res)
+}
+
+// requireClosed refuses to pick a winner while bidding is open, and on a
+// sealed element while there are bids left to reveal before the reveal time.
+func (s *SmartContract) requireClosed(ctx contractapi.TransactionContextInterface, res *ResMarket) error {
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+	now := int(_time.AsTime().UnixMicro())
+
+	if res.CloseTime != 0 && now < res.CloseTime {
+		return fmt.Errorf("bidding on %s is open until %s", res.Id, time.UnixMicro(int64(res.CloseTime)).UTC().Format(time.RFC3339))
+	}
+
+	if res.Sealed && now < res.RevealTime && len(res.Buyers) < len(res.Commitments) {
+		return fmt.Errorf("%d of %d bids on %s are revealed, the others can be until %s", len(res.Buyers), len(res.Commitments), res.Id, time.UnixMicro(int64(res.RevealTime)).UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// pickWinner ranks the bids by the element's policy. Ties go to the earlier
+// bid, then the higher one, then the org name, so every peer picks the same.
+func pickWinner(res *ResMarket) (BuyerInfo, error) {
+	bids := []BuyerInfo{}
+	for _, by := range res.Buyers {
+		if by.Price >= res.Price {
+			bids = append(bids, by)
+		}
+	}
+
+	if len(bids) == 0 {
+		return BuyerInfo{}, fmt.Errorf("no bids on %s", res.Id)
+	}
+
+	sort.Slice(bids, func(i, j int) bool {
+		a, b := bids[i], bids[j]
+		if res.Policy == policyHighest && a.Price != b.Price {
+			return a.Price > b.Price
+		}
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		if a.Price != b.Price {
+			return a.Price > b.Price
+		}
+		return a.Org < b.Org
+	})
+
+	return bids[0], nil
+}
+
+// PickWinner returns the bid SettleMarketElement would pick now.
+func (s *SmartContract) PickWinner(ctx contractapi.TransactionContextInterface, id string) (BuyerInfo, error) {
+	res, err := s.getResMarketElement(ctx, id)
+	if err != nil {
+		return BuyerInfo{}, err
+	}
+
+	if res.Policy == "" || res.Policy == policyOwner {
+		return BuyerInfo{}, fmt.Errorf("the owner of %s picks the winner", id)
+	}
+
+	return pickWinner(res)
+}
+
+// SettleMarketElement locks a closed element to the winner its policy picks.
+// The owner ends it in the same go and, like with EndMarketElement, passes the
+// credentials for the winner in the "ssh" transient field, see PickWinner. A
+// bidder settling it only locks it and gets the other bids refunded, the owner
+// then ends it with EndMarketElement.
+func (s *SmartContract) SettleMarketElement(ctx contractapi.TransactionContextInterface, id string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.getResMarketElement(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// settling locks and ends, so the owner needs whatever approval those need
+	if res.OwnerOrg == org {
+		for _, function := range []string{"SettleMarketElement", "LockMarketElement", "EndMarketElement"} {
+			if err := s.requireNoApproval(ctx, org, function); err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.settleMarketElement(ctx, org, id)
+}
+
+func (s *SmartContract) settleMarketElement(ctx contractapi.TransactionContextInterface, org string, id string) error {
+	res, err := s.getResMarketElement(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := res.Buyers[org]; !ok && res.OwnerOrg != org {
+		return fmt.Errorf("only the owner or a bidder can settle a market element")
+	}
+
+	if res.Status != "open" {
+		return fmt.Errorf("can only settle a opening market element")
+	}
+
+	if res.Policy == "" || res.Policy == policyOwner {
+		return fmt.Errorf("the owner of %s picks the winner, use LockMarketElement", id)
+	}
+
+	if err := s.requireClosed(ctx, res); err != nil {
+		return err
+	}
+
+	winner, err := pickWinner(res)
+	if err != nil {
+		return err
+	}
+
+	res.Status = "locked"
+	res.Winner = winner.Org
+
+	if res.OwnerOrg != org {
+		b := newCreditBatch(ctx)
+		err = b.releaseBids(res, res.Winner)
+		if err != nil {
+			return err
+		}
+
+		err = b.commit()
+		if err != nil {
+			return err
+		}
+
+		return s.putResMarketElement(ctx, id, res)
+	}
+
+	return s.closeMarketElement(ctx, res)
+}
@@ -25,6 +25,8 @@ type ComputeRes struct {
 
 	Name string `json:"Name"`
 
+	Labels map[string]string `json:"Labels,omitempty" metadata:",optional"`
+
 	State string `json:"State"`
 
 	OwnerOrg string `json:"OwnerOrg"`
@@ -35,23 +37,85 @@ type ComputeRes struct {
 	User string `json:"User"`
 
 	Details ComputeResUpdate `json:"Details"`
+	Spec    HardwareSpec     `json:"Spec"`
+
+	// ExternalId is the owner's inventory id of an imported resource
+	ExternalId string `json:"ExternalId,omitempty" metadata:",optional"`
+
+	// AccessLogs are kept by older versions only, see MigrateAccessLogs.
+	AccessLogs []Access `json:"AccessLogs,omitempty" metadata:",optional"`
+
+	AccessPolicies []AccessPolicy `json:"AccessPolicies,omitempty" metadata:",optional"`
+
+	StateHistory []StateChange `json:"StateHistory,omitempty" metadata:",optional"`
+
+	// LastSeen is read from the resource's Heartbeat record, Online only
+	// changes when MarkOffline or a heartbeat after it moves the resource.
+	// Monitored is set by the first heartbeat, until then whether the
+	// resource is online is unknown.
+	LastSeen  int  `json:"LastSeen"`
+	Online    bool `json:"Online"`
+	Monitored bool `json:"Monitored"`
+
+	Leases []Lease `json:"Leases,omitempty" metadata:",optional"`
 
-	SSHAccessDetails SSHAccessDetails `json:"SSHAccessDetails"`
+	Maintenance []MaintenanceWindow `json:"Maintenance,omitempty" metadata:",optional"`
 
-	AccessLogs []Access `json:"AccessLogs"`
+	// Rental is the running whole machine rental, see TerminateRental
+	Rental *Rental `json:"Rental,omitempty" metadata:",optional"`
+
+	// CredentialsTx is the last transaction that wrote or removed any org's
+	// credentials for the resource or one of its slices. The credentials
+	// live in implicit collections, this puts their changes in the record's
+	// change log without their content.
+	CredentialsTx string `json:"CredentialsTx,omitempty" metadata:",optional"`
+}
+
+// offline reports whether the resource's agent stopped sending heartbeats.
+// Resources that never sent one, like those set up before heartbeats, are
+// not known to be offline.
+func (c *ComputeRes) offline() bool {
+	return !c.Online && (c.Monitored || c.LastSeen != 0)
 }
 
 func (c *ComputeRes) IsAvailable() bool {
 	return c.User == ""
 }
 
-func (s *SmartContract) GetComputeRes(ctx contractapi.TransactionContextInterface, id string) (*ComputeRes, error) {
+// visibleTo reports whether org owns, rents or leases a slice of the resource.
+func (c *ComputeRes) visibleTo(org string) bool {
+	return c.OwnerOrg == org || c.UserOrg == org || c.hasLease(org)
+}
 
-	org, err := verifyClientOrgMatchesPeerOrg(ctx)
-	if err != nil {
-		return nil, err
+// redactLeases leaves a slice renter only its own leases.
+func (c *ComputeRes) redactLeases(org string) {
+	leases := []Lease{}
+	for _, l := range c.Leases {
+		if c.OwnerOrg != org && l.Org != org {
+			continue
+		}
+		leases = append(leases, l)
 	}
+	c.Leases = leases
+}
 
+// redactListing hides what the renter does with a resource from everyone but
+// the renting org in list results.
+func (c *ComputeRes) redactListing(org string) {
+	c.redactLeases(org)
+
+	if c.UserOrg != org {
+		c.User = ""
+		c.AccessLogs = nil
+
+		// c.Details.Ip = ""
+		c.Details = ComputeResUpdate{}
+		c.Spec = HardwareSpec{}
+	}
+}
+
+// readComputeRes reads a resource without any authorization or redaction.
+func (s *SmartContract) readComputeRes(ctx contractapi.TransactionContextInterface, id string) (*ComputeRes, error) {
 	res, err := s.readState(ctx, assetComputeRes, id)
 	if err != nil {
 		return nil, err
@@ -63,80 +127,94 @@ func (s *SmartContract) GetComputeRes(ctx contractapi.TransactionContextInterfac
 		return nil, err
 	}
 
-	if asset.UserOrg != org && asset.OwnerOrg != org {
-		return nil, fmt.Errorf("unauthorized access")
-	}
-
-	if asset.UserOrg != org {
-		asset.User = ""
-		asset.AccessLogs = []Access{}
-		asset.SSHAccessDetails = SSHAccessDetails{}
-		asset.Details.Ip = ""
-
-		asset.State = "rented"
-	}
+	asset.normalizeState()
 
 	return &asset, nil
 }
 
-func (s *SmartContract) PutComputeRes(ctx contractapi.TransactionContextInterface, id string, res *ComputeRes) error {
-	_, err := verifyClientOrgMatchesPeerOrg(ctx)
+// GetComputeRes returns the caller's view of a resource with when it was last
+// seen. Only query transactions may call it: the heartbeat record changes on
+// every report and must stay out of the read set of anything that writes.
+func (s *SmartContract) GetComputeRes(ctx contractapi.TransactionContextInterface, id string) (*ComputeRes, error) {
+	asset, err := s.viewComputeRes(ctx, id)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	data, err := json.Marshal(*res)
-
+	asset.LastSeen, err = s.lastSeen(ctx, asset)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// if org != res.UserOrg {
-	// 	return fmt.Errorf("org != UserOrg")
-	// }
-
-	return s.putState(ctx, assetComputeRes, id, data)
+	return asset, nil
 }
 
-func (s *SmartContract) CreateComputeRes(ctx contractapi.TransactionContextInterface, name string) (string, error) {
-
+// viewComputeRes returns the caller's view of a resource, redacted as in
+// GetComputeRes but without reading its heartbeat.
+func (s *SmartContract) viewComputeRes(ctx contractapi.TransactionContextInterface, id string) (*ComputeRes, error) {
 	org, err := verifyClientOrgMatchesPeerOrg(ctx)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	user, err := s.getUserInfo(ctx, org)
+	asset, err := s.readComputeRes(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !asset.visibleTo(org) {
+		return nil, fmt.Errorf("unauthorized access")
+	}
+
+	asset.redactLeases(org)
 
+	_time, err := ctx.GetStub().GetTxTimestamp()
 	if err != nil {
-		return "", fmt.Errorf("failed to get user info: %v", err)
+		return nil, err
 	}
 
-	if user.Role != "admin" {
-		return "", fmt.Errorf("user %s is not authorized to create a compute resource", user.UserName)
+	// renters only need to know about upcoming windows
+	asset.pruneMaintenance(int(_time.AsTime().UnixMicro()))
+
+	if asset.UserOrg != org {
+		asset.User = ""
+		asset.AccessLogs = nil
+		asset.Details.Ip = ""
+		asset.Spec.Ip = ""
 	}
 
-	id := ctx.GetStub().GetTxID()
+	return asset, nil
+}
 
-	rid, err := ctx.GetStub().CreateCompositeKey(resKeyType, []string{id})
+func (s *SmartContract) putComputeRes(ctx contractapi.TransactionContextInterface, id string, res *ComputeRes) error {
+	data, err := json.Marshal(*res)
+	if err != nil {
+		return err
+	}
+
+	return s.putState(ctx, assetComputeRes, id, data)
+}
 
+func (s *SmartContract) CreateComputeRes(ctx contractapi.TransactionContextInterface, name string) (string, error) {
+
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to create composite key: %v", err)
+		return "", err
 	}
 
-	existing, err := s.readState(ctx, assetComputeRes, rid)
+	id := ctx.GetStub().GetTxID()
+
+	existing, err := s.readState(ctx, assetComputeRes, id)
 	if err == nil && existing != nil {
-		return "", fmt.Errorf("the asset %s(%s) already exists", id, rid)
+		return "", fmt.Errorf("the asset %s already exists", id)
 	}
 
 	res := ComputeRes{
 		Id:       id,
 		Name:     name,
-		State:    "uninitialized",
+		State:    stateRegistered,
 		OwnerOrg: org,
 		UserOrg:  org,
-
-		AccessLogs:       []Access{},
-		SSHAccessDetails: SSHAccessDetails{},
 	}
 
 	assetJSON, err := json.Marshal(res)
@@ -149,76 +227,42 @@ func (s *SmartContract) CreateComputeRes(ctx contractapi.TransactionContextInter
 	return id, s.putState(ctx, assetComputeRes, id, assetJSON)
 }
 
+// ListComputeRes returns the machines the org owns or rents.
 func (s *SmartContract) ListComputeRes(ctx contractapi.TransactionContextInterface) ([]*ComputeRes, error) {
 	org, err := verifyClientOrgMatchesPeerOrg(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	resultsIterator, err := ctx.GetStub().GetPrivateDataByRange(assetComputeRes, "", "")
+	assets, err := s.listIndexedComputeRes(ctx, org, ownerIndex, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
-
-	var assets []*ComputeRes
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-
-		fmt.Println("ListComputeRes", string(queryResponse.Value))
-		var asset ComputeRes
-		err = json.Unmarshal(queryResponse.Value, &asset)
-		if err != nil {
-			return nil, err
-		}
 
-		if asset.OwnerOrg != org && asset.UserOrg != org {
-			continue
-		}
-
-		if asset.UserOrg != org {
-			asset.User = ""
-			asset.AccessLogs = []Access{}
-
-			// asset.Details.Ip = ""
-			asset.State = "rented"
-			asset.Details = ComputeResUpdate{}
-			asset.SSHAccessDetails = SSHAccessDetails{}
-		}
-
-		assets = append(assets, &asset)
+	rented, err := s.listIndexedComputeRes(ctx, org, userIndex, func(c *ComputeRes) bool {
+		return c.OwnerOrg != org
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	fmt.Println("ListComputeRes", assets)
-
-	return assets, nil
+	return append(assets, rented...), nil
 }
 
 func (s *SmartContract) QueryComputeRes(ctx contractapi.TransactionContextInterface, id string) (ComputeRes, error) {
-	a, b := s.GetComputeRes(ctx, id)
-	a.SSHAccessDetails = SSHAccessDetails{}
-	return *a, b
+	a, err := s.GetComputeRes(ctx, id)
+	if err != nil {
+		return ComputeRes{}, err
+	}
+	return *a, nil
 }
 
 func (s *SmartContract) AssignUser(ctx contractapi.TransactionContextInterface, id string, user string, userDueDate int) error {
-	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	_, err := verifyClientOrgMatchesPeerOrg(ctx)
 	if err != nil {
 		return err
 	}
 
-	usr, err := s.getUserInfo(ctx, org)
-
-	if err != nil {
-		return fmt.Errorf("failed to get user info: %v", err)
-	}
-
-	if usr.Role != "admin" {
-		return fmt.Errorf("user %s is not authorized to assign a compute resource", usr.UserName)
-	}
-
 	asset, err := s.readState(ctx, assetComputeRes, id)
 	if err != nil {
 		return err
@@ -264,20 +308,18 @@ func (s *SmartContract) UpdateComputeRes(ctx contractapi.TransactionContextInter
 		return err
 	}
 
-	usr, err := s.getUserInfo(ctx, org)
+	asset, err := s.readComputeRes(ctx, Id)
 
 	if err != nil {
-		return fmt.Errorf("failed to get user info: %v", err)
+		return err
 	}
 
-	if usr.Role != "admin" {
-		return fmt.Errorf("user %s is not authorized to update a compute resource", usr.UserName)
+	if asset.UserOrg != org && asset.OwnerOrg != org {
+		return fmt.Errorf("unauthorized access")
 	}
 
-	asset, err := s.GetComputeRes(ctx, Id)
-
-	if err != nil {
-		return err
+	if asset.State == stateDecommissioned {
+		return fmt.Errorf("the resource %s is %s", Id, asset.State)
 	}
 
 	data, err := ctx.GetStub().GetTransient()
@@ -288,6 +330,8 @@ func (s *SmartContract) UpdateComputeRes(ctx contractapi.TransactionContextInter
 
 	var u_res ComputeResUpdate
 
+	reported := false
+
 	u_r, ok := data["update"]
 	if ok {
 		err = json.Unmarshal(u_r, &u_res)
@@ -295,22 +339,56 @@ func (s *SmartContract) UpdateComputeRes(ctx contractapi.TransactionContextInter
 			return err
 		}
 
+		spec, err := specFromLegacy(u_res)
+		if err != nil {
+			return fmt.Errorf("invalid hardware details: %v", err)
+		}
+
 		asset.Details = u_res
-		asset.State = "normal"
+		asset.Spec = spec
+		reported = true
+	}
+
+	h_r, ok := data["spec"]
+	if ok {
+		var spec HardwareSpec
+		err = json.Unmarshal(h_r, &spec)
+		if err != nil {
+			return err
+		}
+
+		err = spec.validate()
+		if err != nil {
+			return fmt.Errorf("invalid hardware spec: %v", err)
+		}
+
+		asset.Spec = spec
+		reported = true
+	}
+
+	// the first hardware report finishes provisioning
+	if reported && (asset.State == stateRegistered || asset.State == stateProvisioning) {
+		err = s.transition(ctx, asset, stateAvailable, "details reported")
+		if err != nil {
+			return err
+		}
 	}
 
-	var s_res SSHAccessDetails
 	s_r, ok := data["ssh"]
 	if ok {
-		err = json.Unmarshal(s_r, &s_res)
+		s_res, err := parseEnvelope(s_r, org)
 		if err != nil {
 			return err
 		}
 
-		asset.SSHAccessDetails = s_res
+		// credentials never go into the shared collection, only into our own org's
+		err = s.putCredentials(ctx, asset, org, Id, s_res)
+		if err != nil {
+			return err
+		}
 	}
 
-	return s.PutComputeRes(ctx, Id, asset)
+	return s.putComputeRes(ctx, Id, asset)
 }
 
 func (s *SmartContract) DelComputeRes(ctx contractapi.TransactionContextInterface, Id string) error {
@@ -319,17 +397,17 @@ func (s *SmartContract) DelComputeRes(ctx contractapi.TransactionContextInterfac
 		return err
 	}
 
-	usr, err := s.getUserInfo(ctx, org)
-
-	if err != nil {
-		return fmt.Errorf("failed to get user info: %v", err)
+	if err := s.requireNoApproval(ctx, org, "DelComputeRes"); err != nil {
+		return err
 	}
 
-	if usr.Role != "admin" {
-		return fmt.Errorf("user %s is not authorized to delete a compute resource", usr.UserName)
-	}
+	return s.delComputeRes(ctx, org, Id)
+}
 
-	asset, err := s.GetComputeRes(ctx, Id)
+// delComputeRes decommissions a resource. The record and its history are
+// kept, only the credentials are removed.
+func (s *SmartContract) delComputeRes(ctx contractapi.TransactionContextInterface, org string, Id string) error {
+	asset, err := s.readComputeRes(ctx, Id)
 
 	if err != nil {
 		return err
@@ -339,57 +417,103 @@ func (s *SmartContract) DelComputeRes(ctx contractapi.TransactionContextInterfac
 		return fmt.Errorf("only owner can delete a compute resource")
 	}
 
-	if asset.OwnerOrg != asset.UserOrg {
+	if asset.OwnerOrg != asset.UserOrg || len(asset.Leases) > 0 {
 		return fmt.Errorf("can't delete a rented compute resource")
 	}
 
-	return ctx.GetStub().DelPrivateData(assetComputeRes, Id)
+	err = s.transition(ctx, asset, stateDecommissioned, "deleted")
+	if err != nil {
+		return err
+	}
+
+	err = s.delCredentials(ctx, asset, org, Id)
+	if err != nil {
+		return err
+	}
+
+	return s.putComputeRes(ctx, Id, asset)
 }
 
-func (s *SmartContract) GetConnectDetails(ctx contractapi.TransactionContextInterface, Id string) (SSHAccessDetails, error) {
+func (s *SmartContract) GetConnectDetails(ctx contractapi.TransactionContextInterface, Id string) (CredentialEnvelope, error) {
 	org, err := verifyClientOrgMatchesPeerOrg(ctx)
 
 	if err != nil {
-		return SSHAccessDetails{}, err
+		return CredentialEnvelope{}, err
 	}
 
-	usr, err := s.getUserInfo(ctx, org)
+	times, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return CredentialEnvelope{}, err
+	}
+
+	asset, err := s.readComputeRes(ctx, Id)
 
 	if err != nil {
-		return SSHAccessDetails{}, err
+		return CredentialEnvelope{}, err
+	}
+
+	now := int(times.AsTime().UnixMicro())
+	if w := asset.maintenanceDuring(now, now+1); w != nil {
+		return CredentialEnvelope{}, fmt.Errorf("the resource is under maintenance until %s: %s", time.UnixMicro(int64(w.End)).UTC().Format(time.RFC3339), w.Reason)
 	}
 
-	if usr.Role != "admin" && !contains(Id, usr.ComputeResList) {
-		return SSHAccessDetails{}, fmt.Errorf("unauthorized access")
+	// slice renters connect through their lease
+	var lease *Lease
+	if asset.UserOrg != org {
+		lease = asset.activeLease(org, int(times.AsTime().UnixMicro()))
+		if lease == nil {
+			return CredentialEnvelope{}, fmt.Errorf("unauthorized access rented res")
+		}
 	}
 
-	asset, err := s.GetComputeRes(ctx, Id)
+	if !asset.policyAllows(ctx, org, permConnect) {
+		usr, err := s.getUserInfo(ctx, org)
+
+		if err != nil {
+			return CredentialEnvelope{}, err
+		}
+
+		if !s.hasPermission(ctx, org, usr, permConnectAll) && !usr.hasGrant(Id, int(times.AsTime().UnixMicro())) {
+			if contains(Id, usr.ComputeResList) {
+				return CredentialEnvelope{}, fmt.Errorf("access grant expired")
+			}
+			return CredentialEnvelope{}, fmt.Errorf("unauthorized access")
+		}
+	}
+
+	user, err := s.GetSubmittingClientIdentity(ctx)
 
 	if err != nil {
-		return SSHAccessDetails{}, err
+		return CredentialEnvelope{}, err
 	}
 
-	if asset.UserOrg != org {
-		return SSHAccessDetails{}, fmt.Errorf("unauthorized access rented res")
+	access := AccessRecord{
+		Res:        Id,
+		Org:        org,
+		AccessTime: now,
+		AccessUser: user,
+		TxId:       ctx.GetStub().GetTxID(),
 	}
 
-	times, err := ctx.GetStub().GetTxTimestamp()
-	if err != nil {
-		return SSHAccessDetails{}, err
+	credId := Id
+	if lease != nil {
+		access.Lease = lease.Id
+		credId = lease.Id
 	}
 
-	asset.AccessLogs = append(asset.AccessLogs, Access{
-		AccessTime: int(times.AsTime().UnixMicro()),
-		AccessUser: usr.UserName,
-	})
+	creds, err := s.getCredentials(ctx, org, credId)
 
-	err = s.PutComputeRes(ctx, Id, asset)
+	if err != nil {
+		return CredentialEnvelope{}, err
+	}
+
+	err = s.putAccessRecord(ctx, &access)
 
 	if err != nil {
-		return SSHAccessDetails{}, err
+		return CredentialEnvelope{}, err
 	}
 
-	return asset.SSHAccessDetails, nil
+	return *creds, nil
 }
 
 func (s *SmartContract) ClaimRent(ctx contractapi.TransactionContextInterface, id string) error {
@@ -399,59 +523,70 @@ func (s *SmartContract) ClaimRent(ctx contractapi.TransactionContextInterface, i
 		return err
 	}
 
-	asset, err := s.GetComputeRes(ctx, id)
+	b := newCreditBatch(ctx)
+	err = s.claimRent(ctx, b, org, id)
 	if err != nil {
 		return err
 	}
-	if asset.OwnerOrg != org {
-		return fmt.Errorf("only owner can claim a rent")
-	}
 
-	if asset.UserOrg == org {
-		return fmt.Errorf("not rent")
-	}
+	return b.commit()
+}
 
-	_time, err := ctx.GetStub().GetTxTimestamp()
+// claimRent reclaims the resource from its renter, the refunds go in b.
+func (s *SmartContract) claimRent(ctx contractapi.TransactionContextInterface, b *creditBatch, org string, id string) error {
+	asset, err := s.readComputeRes(ctx, id)
 	if err != nil {
 		return err
 	}
-
-	if time.UnixMicro(int64(asset.UserOrgDueDate)).Before(_time.AsTime()) {
-		asset.UserOrg = org
-		asset.UserOrgDueDate = 0
-		return s.PutComputeRes(ctx, id, asset)
-	} else {
-		return fmt.Errorf("not time to claim")
+	if asset.OwnerOrg != org {
+		return fmt.Errorf("only owner can claim a rent")
 	}
 
-}
+	if asset.UserOrg == org {
+		claimed, err := s.claimLeases(ctx, asset)
+		if err != nil {
+			return err
+		}
 
-func (s *SmartContract) GetConnectionLogs(ctx contractapi.TransactionContextInterface, id string) ([]Access, error) {
-	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+		if claimed == 0 {
+			return fmt.Errorf("not rent")
+		}
 
-	if err != nil {
-		return nil, err
+		return s.putComputeRes(ctx, id, asset)
 	}
 
-	usr, err := s.getUserInfo(ctx, org)
+	if err := asset.requireState(stateRented); err != nil {
+		return err
+	}
 
+	_time, err := ctx.GetStub().GetTxTimestamp()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if usr.Role != "admin" {
-		return nil, fmt.Errorf("unauthorized access")
-	}
+	if time.UnixMicro(int64(asset.UserOrgDueDate)).Before(_time.AsTime()) {
+		// the renter's copy of the credentials goes away with the rental
+		err = s.delCredentials(ctx, asset, asset.UserOrg, id)
+		if err != nil {
+			return err
+		}
+
+		err = endRental(b, asset)
+		if err != nil {
+			return err
+		}
 
-	asset, err := s.GetComputeRes(ctx, id)
+		asset.UserOrg = org
+		asset.UserOrgDueDate = 0
 
-	if err != nil {
-		return nil, err
-	}
+		err = s.transition(ctx, asset, stateAvailable, "rent claimed")
+		if err != nil {
+			return err
+		}
 
-	if asset.UserOrg != org {
-		return nil, fmt.Errorf("unauthorized access rented res")
+		return s.putComputeRes(ctx, id, asset)
+	} else {
+		return fmt.Errorf("not time to claim")
 	}
 
-	return asset.AccessLogs, nil
 }
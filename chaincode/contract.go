@@ -8,9 +8,29 @@ type SmartContract struct {
 	contractapi.Contract
 }
 
+func newSmartContract() *SmartContract {
+	s := &SmartContract{}
+	s.BeforeTransaction = s.beforeTransaction
+	return s
+}
+
 const (
 	userKeyType = "User"
-	resKeyType  = "ComputeRes"
+	roleKeyType = "Role"
+
+	proposalKeyType       = "Proposal"
+	approvalPolicyKeyType = "ApprovalPolicy"
+	orgKeyType            = "OrgKey"
+	poolKeyType           = "Pool"
+	accessKeyType         = "Access"
+	changeKeyType         = "Change"
+	changeHeadKeyType     = "ChangeHead"
+	sealedBidKeyType      = "SealedBid"
+	offerKeyType          = "Offer"
+	creditAccountKeyType  = "CreditAccount"
+	creditEntryKeyType    = "CreditEntry"
+	creditIssuerKeyType   = "CreditIssuer"
+	heartbeatKeyType      = "Heartbeat"
 
 	_rootuser = "RootUser"
 
@@ -18,4 +38,8 @@ const (
 	assetUser       = "assetUsers"
 
 	assetMarket = "market"
+
+	assetAccessLogs = "accessLogs"
+
+	assetCredits = "credits"
 )
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// CredentialEnvelope is an SSHAccessDetails payload sealed by an org's gateway
+// for the org named in Org. The chaincode only stores and routes envelopes,
+// it never sees the key that opens them.
+type CredentialEnvelope struct {
+	Version int    `json:"v"`
+	Org     string `json:"org"`
+	Epk     string `json:"epk"`
+	Key     string `json:"key"`
+	Nonce   string `json:"nonce"`
+	Data    string `json:"data"`
+}
+
+func parseEnvelope(data []byte, org string) (*CredentialEnvelope, error) {
+	var env CredentialEnvelope
+	err := json.Unmarshal(data, &env)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential envelope: %v", err)
+	}
+
+	if env.Version != 1 {
+		return nil, fmt.Errorf("unsupported credential envelope version %d", env.Version)
+	}
+
+	if env.Org != org {
+		return nil, fmt.Errorf("credential envelope is sealed for %s, not %s", env.Org, org)
+	}
+
+	for _, field := range []string{env.Epk, env.Key, env.Nonce, env.Data} {
+		if _, err := base64.StdEncoding.DecodeString(field); err != nil || field == "" {
+			return nil, fmt.Errorf("invalid credential envelope encoding")
+		}
+	}
+
+	return &env, nil
+}
+
+func (s *SmartContract) getCredentials(ctx contractapi.TransactionContextInterface, org string, id string) (*CredentialEnvelope, error) {
+	data, err := ctx.GetStub().GetPrivateData(implicitCollection(org), id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("no credentials for %s in %s", id, org)
+	}
+
+	return parseEnvelope(data, org)
+}
+
+// putCredentials stores the org's credentials for asset, or for its slice id,
+// and marks the change on asset. The caller writes asset afterwards, which
+// logs the change.
+func (s *SmartContract) putCredentials(ctx contractapi.TransactionContextInterface, asset *ComputeRes, org string, id string, env *CredentialEnvelope) error {
+	data, err := json.Marshal(*env)
+	if err != nil {
+		return err
+	}
+
+	asset.CredentialsTx = ctx.GetStub().GetTxID()
+
+	return ctx.GetStub().PutPrivateData(implicitCollection(org), id, data)
+}
+
+// delCredentials removes credentials like putCredentials stores them.
+func (s *SmartContract) delCredentials(ctx contractapi.TransactionContextInterface, asset *ComputeRes, org string, id string) error {
+	asset.CredentialsTx = ctx.GetStub().GetTxID()
+
+	return ctx.GetStub().DelPrivateData(implicitCollection(org), id)
+}
+
+// handOverCredentials writes the credentials a renter org uses for a resource
+// into the renter's implicit collection. The owner's gateway seals them for
+// the renter and passes them in the given transient field, so they never go
+// into a shared collection.
+func (s *SmartContract) handOverCredentials(ctx contractapi.TransactionContextInterface, asset *ComputeRes, field string, renter string, id string) error {
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return err
+	}
+
+	s_r, ok := transient[field]
+	if !ok {
+		return fmt.Errorf("credentials sealed for %s must be supplied in the %s transient field", renter, field)
+	}
+
+	env, err := parseEnvelope(s_r, renter)
+	if err != nil {
+		return err
+	}
+
+	return s.putCredentials(ctx, asset, renter, id, env)
+}
+
+// GetCredentials returns the org's sealed credentials for a resource without
+// recording an access, so the gateway can reseal them for a renter.
+func (s *SmartContract) GetCredentials(ctx contractapi.TransactionContextInterface, id string) (CredentialEnvelope, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return CredentialEnvelope{}, err
+	}
+
+	if _, err := s.viewComputeRes(ctx, id); err != nil {
+		return CredentialEnvelope{}, err
+	}
+
+	env, err := s.getCredentials(ctx, org, id)
+	if err != nil {
+		return CredentialEnvelope{}, err
+	}
+
+	return *env, nil
+}
+
+// SetOrgKey publishes the public half of the org gateway's credential key so
+// other orgs' gateways can seal credentials for it.
+func (s *SmartContract) SetOrgKey(ctx contractapi.TransactionContextInterface, key string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 32 {
+		return fmt.Errorf("the org key must be a base64 encoded X25519 public key")
+	}
+
+	kid, err := ctx.GetStub().CreateCompositeKey(orgKeyType, []string{org})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	return s.putState(ctx, assetUser, kid, []byte(key))
+}
+
+func (s *SmartContract) GetOrgKey(ctx contractapi.TransactionContextInterface, org string) (string, error) {
+	kid, err := ctx.GetStub().CreateCompositeKey(orgKeyType, []string{org})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	key, err := s.readState(ctx, assetUser, kid)
+	if err != nil {
+		return "", fmt.Errorf("%s has not published a credential key", org)
+	}
+
+	return string(key), nil
+}
+
+// ListOrgKeys returns the published credential keys by org.
+func (s *SmartContract) ListOrgKeys(ctx contractapi.TransactionContextInterface) (map[string]string, error) {
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(assetUser, orgKeyType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	keys := map[string]string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, parts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		keys[parts[0]] = string(queryResponse.Value)
+	}
+
+	return keys, nil
+}
+
+// ListLegacyCredentials returns credentials older versions kept in clear,
+// either inside the shared ComputeRes record or unsealed in the org's implicit
+// collection, so the gateway can seal them for MigrateCredentials.
+func (s *SmartContract) ListLegacyCredentials(ctx contractapi.TransactionContextInterface) (map[string]SSHAccessDetails, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByRange(assetComputeRes, "", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	legacyCreds := make(map[string]SSHAccessDetails)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var legacy struct {
+			Id               string            `json:"Id"`
+			OwnerOrg         string            `json:"OwnerOrg"`
+			SSHAccessDetails *SSHAccessDetails `json:"SSHAccessDetails"`
+		}
+		err = json.Unmarshal(queryResponse.Value, &legacy)
+		if err != nil {
+			return nil, err
+		}
+
+		if legacy.OwnerOrg != org {
+			continue
+		}
+
+		if legacy.SSHAccessDetails != nil {
+			legacyCreds[legacy.Id] = *legacy.SSHAccessDetails
+			continue
+		}
+
+		data, err := ctx.GetStub().GetPrivateData(implicitCollection(org), legacy.Id)
+		if err != nil || data == nil {
+			continue
+		}
+
+		if _, err := parseEnvelope(data, org); err == nil {
+			continue
+		}
+
+		var plain SSHAccessDetails
+		if json.Unmarshal(data, &plain) == nil {
+			legacyCreds[legacy.Id] = plain
+		}
+	}
+
+	return legacyCreds, nil
+}
+
+// MigrateCredentials stores the sealed credentials passed in the
+// "credentials" transient field (a map of resource id to envelope) and
+// rewrites the matching ComputeRes records without any clear text copy.
+func (s *SmartContract) MigrateCredentials(ctx contractapi.TransactionContextInterface) (int, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return 0, err
+	}
+
+	var sealed map[string]json.RawMessage
+	err = json.Unmarshal(transient["credentials"], &sealed)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read credentials transient field: %v", err)
+	}
+
+	ids := make([]string, 0, len(sealed))
+	for id := range sealed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	migrated := 0
+	for _, id := range ids {
+		env, err := parseEnvelope(sealed[id], org)
+		if err != nil {
+			return migrated, fmt.Errorf("%s: %v", id, err)
+		}
+
+		asset, err := s.readComputeRes(ctx, id)
+		if err != nil {
+			return migrated, err
+		}
+
+		if asset.OwnerOrg != org {
+			return migrated, fmt.Errorf("%s is not owned by %s", id, org)
+		}
+
+		err = s.putCredentials(ctx, asset, org, id, env)
+		if err != nil {
+			return migrated, err
+		}
+
+		// rewriting the record drops the legacy SSHAccessDetails field
+		err = s.putComputeRes(ctx, id, asset)
+		if err != nil {
+			return migrated, err
+		}
+
+		migrated++
+	}
+
+	return migrated, nil
+}
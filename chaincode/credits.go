@@ -0,0 +1,520 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// CreditAccount is an org's credit. Balance is what the org can spend, Held
+// what is in escrow for its open bids.
+type CreditAccount struct {
+	Org     string `json:"Org"`
+	Balance int    `json:"Balance"`
+	Held    int    `json:"Held"`
+}
+
+// CreditEntry is one line of an org's statement. Amount and Escrow are the
+// changes to the account's Balance and Held, which are given as they are
+// after the entry.
+type CreditEntry struct {
+	Org          string `json:"Org"`
+	Kind         string `json:"Kind"`
+	Amount       int    `json:"Amount"`
+	Escrow       int    `json:"Escrow"`
+	Balance      int    `json:"Balance"`
+	Held         int    `json:"Held"`
+	Counterparty string `json:"Counterparty"`
+	Ref          string `json:"Ref"`
+	Time         int    `json:"Time"`
+	TxId         string `json:"TxId"`
+}
+
+type CreditStatement struct {
+	Entries  []*CreditEntry `json:"Entries"`
+	Bookmark string         `json:"Bookmark"`
+}
+
+const (
+	creditMint     = "mint"
+	creditTransfer = "transfer"
+	creditHold     = "hold"
+	creditRefund   = "refund"
+	creditPayment  = "payment"
+	creditReceipt  = "receipt"
+)
+
+// creditBatch collects the credit movements of a transaction. Reads only see
+// committed state, so every account is read once and written once by commit.
+type creditBatch struct {
+	ctx      contractapi.TransactionContextInterface
+	accounts map[string]*CreditAccount
+	entries  []*CreditEntry
+}
+
+func newCreditBatch(ctx contractapi.TransactionContextInterface) *creditBatch {
+	return &creditBatch{ctx: ctx, accounts: map[string]*CreditAccount{}}
+}
+
+func creditAccountKey(ctx contractapi.TransactionContextInterface, org string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(creditAccountKeyType, []string{org})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	return key, nil
+}
+
+func (b *creditBatch) account(org string) (*CreditAccount, error) {
+	if acc, ok := b.accounts[org]; ok {
+		return acc, nil
+	}
+
+	key, err := creditAccountKey(b.ctx, org)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := b.ctx.GetStub().GetPrivateData(assetCredits, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the account of %s: %w", org, err)
+	}
+
+	acc := &CreditAccount{Org: org}
+	if data != nil {
+		err = json.Unmarshal(data, acc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b.accounts[org] = acc
+	return acc, nil
+}
+
+// post moves amount into the org's balance and escrow into its held credit,
+// either may be negative but neither may end up below zero.
+func (b *creditBatch) post(org string, kind string, amount int, escrow int, counterparty string, ref string) error {
+	if amount == 0 && escrow == 0 {
+		return nil
+	}
+
+	acc, err := b.account(org)
+	if err != nil {
+		return err
+	}
+
+	if acc.Balance+amount < 0 {
+		return fmt.Errorf("%s has %d credits available, %d needed", org, acc.Balance, -amount)
+	}
+
+	if acc.Held+escrow < 0 {
+		return fmt.Errorf("%s has %d credits held, %d needed", org, acc.Held, -escrow)
+	}
+
+	acc.Balance += amount
+	acc.Held += escrow
+
+	b.entries = append(b.entries, &CreditEntry{
+		Org:          org,
+		Kind:         kind,
+		Amount:       amount,
+		Escrow:       escrow,
+		Balance:      acc.Balance,
+		Held:         acc.Held,
+		Counterparty: counterparty,
+		Ref:          ref,
+	})
+
+	return nil
+}
+
+// hold moves amount of the org's balance into escrow for ref, a negative
+// amount refunds.
+func (b *creditBatch) hold(org string, amount int, ref string) error {
+	if amount < 0 {
+		return b.post(org, creditRefund, -amount, amount, "", ref)
+	}
+
+	return b.post(org, creditHold, -amount, amount, "", ref)
+}
+
+// payHeld pays amount the payer holds in escrow for ref to the payee.
+func (b *creditBatch) payHeld(payer string, payee string, amount int, ref string) error {
+	err := b.post(payer, creditPayment, 0, -amount, payee, ref)
+	if err != nil {
+		return err
+	}
+
+	return b.post(payee, creditReceipt, amount, 0, payer, ref)
+}
+
+// pay pays amount of the payer's balance to the payee.
+func (b *creditBatch) pay(payer string, payee string, amount int, ref string) error {
+	err := b.post(payer, creditPayment, -amount, 0, payee, ref)
+	if err != nil {
+		return err
+	}
+
+	return b.post(payee, creditReceipt, amount, 0, payer, ref)
+}
+
+func (b *creditBatch) commit() error {
+	_time, err := b.ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+	now := int(_time.AsTime().UnixMicro())
+	txid := b.ctx.GetStub().GetTxID()
+
+	orgs := []string{}
+	for org := range b.accounts {
+		orgs = append(orgs, org)
+	}
+	sort.Strings(orgs)
+
+	for _, org := range orgs {
+		key, err := creditAccountKey(b.ctx, org)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(*b.accounts[org])
+		if err != nil {
+			return err
+		}
+
+		err = b.ctx.GetStub().PutPrivateData(assetCredits, key, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, e := range b.entries {
+		e.Time = now
+		e.TxId = txid
+
+		key, err := rangeKey(b.ctx, creditEntryKeyType, e.Org, fmt.Sprintf("%020d", now), txid, fmt.Sprintf("%04d", i))
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(*e)
+		if err != nil {
+			return err
+		}
+
+		err = b.ctx.GetStub().PutPrivateData(assetCredits, key, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SmartContract) getCreditIssuer(ctx contractapi.TransactionContextInterface) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(creditIssuerKeyType, []string{})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	data, err := ctx.GetStub().GetPrivateData(assetCredits, key)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+func (s *SmartContract) putCreditIssuer(ctx contractapi.TransactionContextInterface, issuer string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(creditIssuerKeyType, []string{})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	return ctx.GetStub().PutPrivateData(assetCredits, key, []byte(issuer))
+}
+
+// GetCreditIssuer returns the org that mints credits, empty if there is none
+// yet.
+func (s *SmartContract) GetCreditIssuer(ctx contractapi.TransactionContextInterface) (string, error) {
+	return s.getCreditIssuer(ctx)
+}
+
+// InitLedger is the chaincode's init function, deploy it with
+// --init-required and -cci InitLedger. The org that instantiates the
+// chaincode becomes the credit issuer. Every new definition runs it again,
+// so it also indexes records written by a version without indexes.
+func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	init, err := isInit(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !init {
+		return fmt.Errorf("InitLedger only runs when the chaincode is instantiated")
+	}
+
+	if _, err := s.rebuildIndexes(ctx); err != nil {
+		return err
+	}
+
+	current, err := s.getCreditIssuer(ctx)
+	if err != nil {
+		return err
+	}
+
+	// a new definition of the chaincode is initialized again
+	if current != "" {
+		return nil
+	}
+
+	return s.putCreditIssuer(ctx, org)
+}
+
+// SetCreditIssuer hands issuing over to another org. Only the issuer can, the
+// first one is set by InitLedger.
+func (s *SmartContract) SetCreditIssuer(ctx contractapi.TransactionContextInterface, issuer string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	if issuer == "" {
+		return fmt.Errorf("the issuer can't be empty")
+	}
+
+	current, err := s.getCreditIssuer(ctx)
+	if err != nil {
+		return err
+	}
+
+	if current == "" {
+		return fmt.Errorf("there is no issuer, the chaincode must be instantiated with InitLedger")
+	}
+
+	if current != org {
+		return fmt.Errorf("only the issuer %s can hand over issuing", current)
+	}
+
+	return s.putCreditIssuer(ctx, issuer)
+}
+
+// MintCredits adds amount to an org's allowance. Only the issuer mints.
+func (s *SmartContract) MintCredits(ctx contractapi.TransactionContextInterface, to string, amount int) (CreditAccount, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return CreditAccount{}, err
+	}
+
+	issuer, err := s.getCreditIssuer(ctx)
+	if err != nil {
+		return CreditAccount{}, err
+	}
+
+	if issuer != org {
+		return CreditAccount{}, fmt.Errorf("only the issuer can mint credits")
+	}
+
+	if amount <= 0 {
+		return CreditAccount{}, fmt.Errorf("the amount must be positive")
+	}
+
+	b := newCreditBatch(ctx)
+	err = b.post(to, creditMint, amount, 0, org, "")
+	if err != nil {
+		return CreditAccount{}, err
+	}
+
+	return *b.accounts[to], b.commit()
+}
+
+// TransferCredits pays amount of the org's balance to another org.
+func (s *SmartContract) TransferCredits(ctx contractapi.TransactionContextInterface, to string, amount int) (CreditAccount, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return CreditAccount{}, err
+	}
+
+	if amount <= 0 {
+		return CreditAccount{}, fmt.Errorf("the amount must be positive")
+	}
+
+	if to == org || to == "" {
+		return CreditAccount{}, fmt.Errorf("invalid recipient %q", to)
+	}
+
+	b := newCreditBatch(ctx)
+	err = b.post(org, creditTransfer, -amount, 0, to, "")
+	if err != nil {
+		return CreditAccount{}, err
+	}
+
+	err = b.post(to, creditTransfer, amount, 0, org, "")
+	if err != nil {
+		return CreditAccount{}, err
+	}
+
+	return *b.accounts[org], b.commit()
+}
+
+// GetCreditBalance returns the org's account.
+func (s *SmartContract) GetCreditBalance(ctx contractapi.TransactionContextInterface) (CreditAccount, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return CreditAccount{}, err
+	}
+
+	acc, err := newCreditBatch(ctx).account(org)
+	if err != nil {
+		return CreditAccount{}, err
+	}
+
+	return *acc, nil
+}
+
+// GetCreditStatement returns the org's entries between from and to
+// (microseconds, 0 for no bound) in time order, one page at a time. Pass the
+// returned bookmark to get the next page.
+func (s *SmartContract) GetCreditStatement(ctx contractapi.TransactionContextInterface, from int, to int, pageSize int, bookmark string) (CreditStatement, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return CreditStatement{}, err
+	}
+
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	prefix, err := rangeKey(ctx, creditEntryKeyType, org)
+	if err != nil {
+		return CreditStatement{}, err
+	}
+
+	start, end, err := timeRange(prefix, from, to, bookmark)
+	if err != nil {
+		return CreditStatement{}, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByRange(assetCredits, start, end)
+	if err != nil {
+		return CreditStatement{}, err
+	}
+	defer resultsIterator.Close()
+
+	page := CreditStatement{Entries: []*CreditEntry{}}
+	var after string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return CreditStatement{}, err
+		}
+
+		if len(page.Entries) == pageSize {
+			page.Bookmark = base64.RawURLEncoding.EncodeToString([]byte(after))
+			break
+		}
+
+		var e CreditEntry
+		err = json.Unmarshal(queryResponse.Value, &e)
+		if err != nil {
+			return CreditStatement{}, err
+		}
+
+		page.Entries = append(page.Entries, &e)
+		after = queryResponse.Key
+	}
+
+	return page, nil
+}
+
+// settleBids pays the winner's escrow of a market element to the owner and
+// releases the other bids, see releaseBids. With an empty winner every bid is
+// released.
+func settleBids(ctx contractapi.TransactionContextInterface, res *ResMarket, winner string) error {
+	b := newCreditBatch(ctx)
+
+	err := b.releaseBids(res, winner)
+	if err != nil {
+		return err
+	}
+
+	if by, ok := res.Buyers[winner]; ok {
+		err = b.payHeld(winner, res.OwnerOrg, by.Held, res.Id)
+		if err != nil {
+			return err
+		}
+
+		by.Held = 0
+		res.Buyers[winner] = by
+	}
+
+	return b.commit()
+}
+
+// releaseBids refunds the bids on a market element except the winner's, which
+// stays in escrow. The deposits of sealed bids left unrevealed past the reveal
+// time go to the owner, the others are refunded.
+func (b *creditBatch) releaseBids(res *ResMarket, winner string) error {
+	_time, err := b.ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+	expired := res.Sealed && int(_time.AsTime().UnixMicro()) >= res.RevealTime
+
+	orgs := []string{}
+	for org := range res.Commitments {
+		orgs = append(orgs, org)
+	}
+	sort.Strings(orgs)
+
+	for _, org := range orgs {
+		c := res.Commitments[org]
+
+		if expired {
+			err = b.payHeld(org, res.OwnerOrg, c.Held, res.Id)
+		} else {
+			err = b.hold(org, -c.Held, res.Id)
+		}
+		if err != nil {
+			return err
+		}
+
+		c.Held = 0
+		res.Commitments[org] = c
+	}
+
+	orgs = []string{}
+	for org := range res.Buyers {
+		if org != winner {
+			orgs = append(orgs, org)
+		}
+	}
+	sort.Strings(orgs)
+
+	for _, org := range orgs {
+		by := res.Buyers[org]
+
+		err = b.hold(org, -by.Held, res.Id)
+		if err != nil {
+			return err
+		}
+
+		by.Held = 0
+		res.Buyers[org] = by
+	}
+
+	return nil
+}
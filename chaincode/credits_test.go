@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/v2/shim"
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// creditStub is the part of the chaincode stub the credit batch uses. Writes
+// only show up in reads once the transaction is committed, like on a peer,
+// and every key may be written once per transaction.
+type creditStub struct {
+	shim.ChaincodeStubInterface
+	t      *testing.T
+	state  map[string][]byte
+	writes map[string][]byte
+	now    time.Time
+}
+
+func newCreditStub(t *testing.T) *creditStub {
+	return &creditStub{t: t, state: map[string][]byte{}, writes: map[string][]byte{}, now: time.Unix(1700000000, 0)}
+}
+
+func (m *creditStub) GetTxID() string { return "tx1" }
+
+func (m *creditStub) GetTxTimestamp() (*timestamppb.Timestamp, error) {
+	return timestamppb.New(m.now), nil
+}
+
+func (m *creditStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return shim.CreateCompositeKey(objectType, attributes)
+}
+
+func (m *creditStub) GetPrivateData(collection string, key string) ([]byte, error) {
+	return m.state[collection+"/"+key], nil
+}
+
+func (m *creditStub) PutPrivateData(collection string, key string, value []byte) error {
+	if _, ok := m.writes[collection+"/"+key]; ok {
+		m.t.Errorf("%q is written twice in one transaction", key)
+	}
+	m.writes[collection+"/"+key] = value
+	return nil
+}
+
+// commit ends the transaction.
+func (m *creditStub) commit() {
+	for k, v := range m.writes {
+		m.state[k] = v
+	}
+	m.writes = map[string][]byte{}
+}
+
+func (m *creditStub) ctx() contractapi.TransactionContextInterface {
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(m)
+	return ctx
+}
+
+func (m *creditStub) setAccount(acc CreditAccount) {
+	key, err := creditAccountKey(m.ctx(), acc.Org)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+
+	data, err := json.Marshal(acc)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	m.state[assetCredits+"/"+key] = data
+}
+
+func (m *creditStub) account(org string) CreditAccount {
+	acc, err := newCreditBatch(m.ctx()).account(org)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return *acc
+}
+
+func (m *creditStub) entries() []CreditEntry {
+	var entries []CreditEntry
+	for k, v := range m.state {
+		if !strings.HasPrefix(k, assetCredits+"/"+creditEntryKeyType+"\x00") {
+			continue
+		}
+
+		var e CreditEntry
+		if err := json.Unmarshal(v, &e); err != nil {
+			m.t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func checkAccount(t *testing.T, got CreditAccount, balance int, held int) {
+	t.Helper()
+	if got.Balance != balance || got.Held != held {
+		t.Errorf("%s has %d available and %d held, want %d and %d", got.Org, got.Balance, got.Held, balance, held)
+	}
+}
+
+func TestCreditBatchHold(t *testing.T) {
+	m := newCreditStub(t)
+	m.setAccount(CreditAccount{Org: "Org2MSP", Balance: 100})
+
+	b := newCreditBatch(m.ctx())
+	if err := b.hold("Org2MSP", 30, "m1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.hold("Org2MSP", -10, "m1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.hold("Org2MSP", 81, "m1"); err == nil {
+		t.Error("held more than the balance")
+	}
+	if err := b.hold("Org2MSP", -21, "m1"); err == nil {
+		t.Error("refunded more than is held")
+	}
+	if err := b.commit(); err != nil {
+		t.Fatal(err)
+	}
+	m.commit()
+
+	checkAccount(t, m.account("Org2MSP"), 80, 20)
+
+	kinds := map[string]int{}
+	for _, e := range m.entries() {
+		kinds[e.Kind] += e.Amount
+	}
+	if kinds[creditHold] != -30 || kinds[creditRefund] != 10 || len(kinds) != 2 {
+		t.Errorf("unexpected entries %v", kinds)
+	}
+}
+
+func TestCreditBatchPayHeld(t *testing.T) {
+	m := newCreditStub(t)
+	m.setAccount(CreditAccount{Org: "Org2MSP", Balance: 5, Held: 50})
+
+	b := newCreditBatch(m.ctx())
+	if err := b.payHeld("Org2MSP", "Org1MSP", 51, "m1"); err == nil {
+		t.Error("paid more than is held")
+	}
+	if err := b.payHeld("Org2MSP", "Org1MSP", 50, "m1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.commit(); err != nil {
+		t.Fatal(err)
+	}
+	m.commit()
+
+	checkAccount(t, m.account("Org2MSP"), 5, 0)
+	checkAccount(t, m.account("Org1MSP"), 50, 0)
+}
+
+func TestCreditBatchWritesAccountsOnce(t *testing.T) {
+	m := newCreditStub(t)
+	m.setAccount(CreditAccount{Org: "Org2MSP", Balance: 100})
+
+	// every movement goes through Org2MSP, the stub fails a second write
+	b := newCreditBatch(m.ctx())
+	for i := 0; i < 3; i++ {
+		if err := b.hold("Org2MSP", 10, "m1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.payHeld("Org2MSP", "Org1MSP", 10, "m1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.pay("Org1MSP", "Org2MSP", 5, "m1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.commit(); err != nil {
+		t.Fatal(err)
+	}
+	m.commit()
+
+	checkAccount(t, m.account("Org2MSP"), 75, 0)
+	checkAccount(t, m.account("Org1MSP"), 25, 0)
+
+	if n := len(m.entries()); n != 11 {
+		t.Errorf("%d entries, want 11", n)
+	}
+}
+
+func TestSettleBids(t *testing.T) {
+	m := newCreditStub(t)
+	m.setAccount(CreditAccount{Org: "Org2MSP", Held: 20})
+	m.setAccount(CreditAccount{Org: "Org3MSP", Held: 15})
+
+	res := &ResMarket{
+		Id:       "m1",
+		OwnerOrg: "Org1MSP",
+		Buyers: map[string]BuyerInfo{
+			"Org2MSP": {Org: "Org2MSP", Price: 20, Held: 20},
+			"Org3MSP": {Org: "Org3MSP", Price: 15, Held: 15},
+		},
+	}
+
+	if err := settleBids(m.ctx(), res, "Org2MSP"); err != nil {
+		t.Fatal(err)
+	}
+	m.commit()
+
+	checkAccount(t, m.account("Org1MSP"), 20, 0)
+	checkAccount(t, m.account("Org2MSP"), 0, 0)
+	checkAccount(t, m.account("Org3MSP"), 15, 0)
+
+	for org, by := range res.Buyers {
+		if by.Held != 0 {
+			t.Errorf("the bid of %s still holds %d", org, by.Held)
+		}
+	}
+}
+
+func TestSettleBidsDeposits(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		after    time.Duration
+		winner   string
+		owner    int
+		bidder   int
+		revealed int
+	}{
+		{name: "removed before the reveal time", after: 0, winner: "", owner: 0, bidder: 10, revealed: 30},
+		{name: "settled after the reveal time", after: 2 * time.Hour, winner: "Org2MSP", owner: 40, bidder: 0, revealed: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newCreditStub(t)
+			m.setAccount(CreditAccount{Org: "Org2MSP", Held: 30})
+			m.setAccount(CreditAccount{Org: "Org3MSP", Held: 10})
+
+			res := &ResMarket{
+				Id:         "m1",
+				OwnerOrg:   "Org1MSP",
+				Price:      10,
+				Sealed:     true,
+				RevealTime: int(m.now.Add(time.Hour).UnixMicro()),
+				Buyers: map[string]BuyerInfo{
+					"Org2MSP": {Org: "Org2MSP", Price: 30, Held: 30},
+				},
+				Commitments: map[string]BidCommitment{
+					"Org2MSP": {Hash: "a"},
+					"Org3MSP": {Hash: "b", Held: 10},
+				},
+			}
+			m.now = m.now.Add(tc.after)
+
+			if err := settleBids(m.ctx(), res, tc.winner); err != nil {
+				t.Fatal(err)
+			}
+			m.commit()
+
+			checkAccount(t, m.account("Org1MSP"), tc.owner, 0)
+			checkAccount(t, m.account("Org2MSP"), tc.revealed, 0)
+			checkAccount(t, m.account("Org3MSP"), tc.bidder, 0)
+
+			if res.Commitments["Org3MSP"].Held != 0 {
+				t.Error("the deposit is still held")
+			}
+		})
+	}
+}
+
+func TestTerminationRefund(t *testing.T) {
+	hour := int(time.Hour.Microseconds())
+	r := &Rental{
+		Start:       0,
+		Paid:        100,
+		Termination: TerminationRules{Allowed: true, Refund: 50},
+	}
+
+	for _, tc := range []struct {
+		end      int
+		byRenter bool
+		want     int
+	}{
+		{end: 0, byRenter: false, want: 100},
+		{end: hour, byRenter: false, want: 75},
+		{end: hour, byRenter: true, want: 37},
+		{end: 3 * hour, byRenter: false, want: 25},
+		{end: 4 * hour, byRenter: true, want: 0},
+	} {
+		if got := r.terminationRefund(4*hour, tc.end, tc.byRenter); got != tc.want {
+			t.Errorf("ending at %d by the renter %v refunds %d, want %d", tc.end, tc.byRenter, got, tc.want)
+		}
+	}
+
+	// the price times the time left doesn't fit in 64 bits
+	long := &Rental{Start: 0, Paid: 1 << 40, Termination: TerminationRules{Refund: 100}}
+	if got := long.terminationRefund(1<<40, 1<<39, true); got != 1<<39 {
+		t.Errorf("refunds %d, want %d", got, 1<<39)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+func offerKey(ctx contractapi.TransactionContextInterface, id string, org string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(offerKeyType, []string{id, org})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	return key, nil
+}
+
+// PutFixedOnMarket lists a whole machine for rent at a fixed price, the first
+// org to accept it with AcceptFixedPrice rents it. The owner can't hand over
+// credentials when that happens, so the "ssh" transient field carries them
+// up front as a JSON object of envelopes keyed by the org each is sealed for.
+// Only those orgs can accept. The envelopes are kept with the element, sealed
+// they are of no use to anyone else.
+func (s *SmartContract) PutFixedOnMarket(ctx contractapi.TransactionContextInterface, asset_id string, duration int, price int) (string, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// accepting locks and ends the element without the owner
+	for _, function := range []string{"LockMarketElement", "EndMarketElement"} {
+		if err := s.requireNoApproval(ctx, org, function); err != nil {
+			return "", err
+		}
+	}
+
+	data, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", err
+	}
+
+	s_r, ok := data["ssh"]
+	if !ok {
+		return "", fmt.Errorf("credentials sealed for the orgs that may accept must be supplied in the ssh transient field")
+	}
+
+	offers := map[string]json.RawMessage{}
+	err = json.Unmarshal(s_r, &offers)
+	if err != nil {
+		return "", fmt.Errorf("invalid ssh details: %v", err)
+	}
+
+	delete(offers, org)
+	if len(offers) == 0 {
+		return "", fmt.Errorf("no org to offer %s to", asset_id)
+	}
+
+	envelopes := map[string][]byte{}
+	for o, v := range offers {
+		env, err := parseEnvelope(v, o)
+		if err != nil {
+			return "", err
+		}
+
+		envelopes[o], err = json.Marshal(*env)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	id, err := s.putResOnMarket(ctx, org, asset_id, ResMarket{
+		Price:      price,
+		Duration:   duration,
+		MarketType: marketFixed,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for o, env := range envelopes {
+		key, err := offerKey(ctx, id, o)
+		if err != nil {
+			return "", err
+		}
+
+		err = s.putState(ctx, assetMarket, key, env)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return id, nil
+}
+
+// delOffers drops the credentials offered with a fixed price element.
+func (s *SmartContract) delOffers(ctx contractapi.TransactionContextInterface, id string) error {
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(assetMarket, offerKeyType, []string{id})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+
+		err = s.delState(ctx, assetMarket, queryResponse.Key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AcceptFixedPrice rents a fixed price element at its price, paid from the
+// org's credit. The machine, its due date and the credentials offered to the
+// org go over and the element ends in the same transaction.
+func (s *SmartContract) AcceptFixedPrice(ctx contractapi.TransactionContextInterface, id string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.getResMarketElement(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if res.MarketType != marketFixed {
+		return fmt.Errorf("the element %s has no fixed price", id)
+	}
+
+	if res.Status != "open" {
+		return fmt.Errorf("the market status can't be modified %s", res.Status)
+	}
+
+	if res.OwnerOrg == org {
+		return fmt.Errorf("you can't make price on your own trades")
+	}
+
+	key, err := offerKey(ctx, id, org)
+	if err != nil {
+		return err
+	}
+
+	data, err := s.readState(ctx, assetMarket, key)
+	if err != nil {
+		return fmt.Errorf("%s is not offered to %s", id, org)
+	}
+
+	env, err := parseEnvelope(data, org)
+	if err != nil {
+		return err
+	}
+
+	compres, err := s.readComputeRes(ctx, res.Res.Id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.requireNoMaintenance(ctx, compres, res.Duration); err != nil {
+		return err
+	}
+
+	b := newCreditBatch(ctx)
+	err = b.pay(org, res.OwnerOrg, res.Price, id)
+	if err != nil {
+		return err
+	}
+
+	err = b.commit()
+	if err != nil {
+		return err
+	}
+
+	err = s.putCredentials(ctx, compres, org, compres.Id, env)
+	if err != nil {
+		return err
+	}
+
+	err = s.delOffers(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+
+	if res.Buyers == nil {
+		res.Buyers = map[string]BuyerInfo{}
+	}
+	res.Buyers[org] = BuyerInfo{
+		Org:   org,
+		Price: res.Price,
+		Date:  int(_time.AsTime().UnixMicro()),
+	}
+	res.Winner = org
+
+	return s.rentOut(ctx, res, compres)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// Heartbeat is the latest ping the gateway received from a resource's agent.
+// It is kept under its own key, so the pings don't rewrite the resource and
+// don't conflict with transactions on it.
+type Heartbeat struct {
+	Id       string `json:"Id"`
+	LastSeen int    `json:"LastSeen"`
+}
+
+// reasonHeartbeatLost marks the resources MarkOffline took offline, the only
+// ones a heartbeat brings back.
+const reasonHeartbeatLost = "no heartbeat"
+
+func heartbeatKey(ctx contractapi.TransactionContextInterface, org string, id string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(heartbeatKeyType, []string{org, id})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	return key, nil
+}
+
+// lastSeen returns when the owner's gateway last heard from the resource, 0
+// if it never did.
+func (s *SmartContract) lastSeen(ctx contractapi.TransactionContextInterface, c *ComputeRes) (int, error) {
+	key, err := heartbeatKey(ctx, c.OwnerOrg, c.Id)
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := ctx.GetStub().GetPrivateData(assetComputeRes, key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read from world state: %w", err)
+	}
+
+	if data == nil {
+		// older records kept it with the resource
+		return c.LastSeen, nil
+	}
+
+	var hb Heartbeat
+	err = json.Unmarshal(data, &hb)
+	if err != nil {
+		return 0, err
+	}
+
+	return hb.LastSeen, nil
+}
+
+// ReportHeartbeats records a batch of heartbeats collected by the org's
+// gateway. Only the reported resources are read. A resource is only written
+// when it comes back online, after MarkOffline took it offline or when it is
+// seen for the first time.
+func (s *SmartContract) ReportHeartbeats(ctx contractapi.TransactionContextInterface, heartbeats []Heartbeat, timeout int) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	if timeout <= 0 {
+		return fmt.Errorf("the timeout must be positive")
+	}
+
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+	now := int(_time.AsTime().UnixMicro())
+
+	seen := make(map[string]int)
+	for _, hb := range heartbeats {
+		// a gateway clock ahead of the ledger must not keep a resource online
+		if hb.LastSeen > now {
+			hb.LastSeen = now
+		}
+		if hb.LastSeen > seen[hb.Id] {
+			seen[hb.Id] = hb.LastSeen
+		}
+	}
+
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		asset, err := s.readComputeRes(ctx, id)
+		if err != nil {
+			// agents of deleted or mistyped resources keep pinging
+			continue
+		}
+
+		if asset.OwnerOrg != org || asset.State == stateDecommissioned {
+			continue
+		}
+
+		last, err := s.lastSeen(ctx, asset)
+		if err != nil {
+			return err
+		}
+
+		if seen[id] > last {
+			last = seen[id]
+
+			key, err := heartbeatKey(ctx, org, id)
+			if err != nil {
+				return err
+			}
+
+			data, err := json.Marshal(Heartbeat{Id: id, LastSeen: last})
+			if err != nil {
+				return err
+			}
+
+			err = ctx.GetStub().PutPrivateData(assetComputeRes, key, data)
+			if err != nil {
+				return err
+			}
+		}
+
+		if asset.Online || now-last > timeout {
+			continue
+		}
+
+		asset.Online = true
+		asset.Monitored = true
+
+		if asset.State == stateOffline && asset.stateReason() == reasonHeartbeatLost {
+			err = s.transition(ctx, asset, stateAvailable, "heartbeat resumed")
+			if err != nil {
+				return err
+			}
+		}
+
+		err = s.putComputeRes(ctx, id, asset)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stateReason returns the reason given for the resource's current state.
+func (c *ComputeRes) stateReason() string {
+	if len(c.StateHistory) == 0 {
+		return ""
+	}
+
+	return c.StateHistory[len(c.StateHistory)-1].Reason
+}
+
+// MarkOffline takes the org's resources whose agent has not been heard from
+// for longer than timeout microseconds offline. Only the heartbeat records are
+// scanned, a resource is written when it goes offline.
+func (s *SmartContract) MarkOffline(ctx contractapi.TransactionContextInterface, timeout int) ([]string, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout <= 0 {
+		return nil, fmt.Errorf("the timeout must be positive")
+	}
+
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	now := int(_time.AsTime().UnixMicro())
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(assetComputeRes, heartbeatKeyType, []string{org})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	offline := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var hb Heartbeat
+		err = json.Unmarshal(queryResponse.Value, &hb)
+		if err != nil {
+			return nil, err
+		}
+
+		if now-hb.LastSeen <= timeout {
+			continue
+		}
+
+		asset, err := s.readComputeRes(ctx, hb.Id)
+		if err != nil || asset.OwnerOrg != org || asset.State == stateDecommissioned {
+			// the resource is gone or was sold
+			err = ctx.GetStub().DelPrivateData(assetComputeRes, queryResponse.Key)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if !asset.Online {
+			continue
+		}
+
+		asset.Online = false
+
+		// listed and rented resources stay as they are until they come back
+		if asset.canTransition(stateOffline) {
+			err = s.transition(ctx, asset, stateOffline, reasonHeartbeatLost)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = s.putComputeRes(ctx, asset.Id, asset)
+		if err != nil {
+			return nil, err
+		}
+
+		offline = append(offline, asset.Id)
+	}
+
+	return offline, nil
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// FieldChange is one top level field of a record before and after a change,
+// as JSON. An empty side means the field was absent.
+type FieldChange struct {
+	Field string `json:"Field"`
+	From  string `json:"From"`
+	To    string `json:"To"`
+}
+
+// ChangeEntry is one link of a record's change log. Hash covers every other
+// field of the entry, PrevHash links it to the entry before and StateHash is
+// the hash of the record as written, empty once the record is deleted.
+type ChangeEntry struct {
+	Seq       int           `json:"Seq"`
+	Record    string        `json:"Record"`
+	Actor     string        `json:"Actor"`
+	Msp       string        `json:"Msp"`
+	TxId      string        `json:"TxId"`
+	Time      int           `json:"Time"`
+	Diff      []FieldChange `json:"Diff"`
+	StateHash string        `json:"StateHash"`
+	PrevHash  string        `json:"PrevHash"`
+	Hash      string        `json:"Hash"`
+}
+
+type HistoryCheck struct {
+	Valid   bool   `json:"Valid"`
+	Entries int    `json:"Entries"`
+	Head    string `json:"Head"`
+	Error   string `json:"Error"`
+}
+
+// historyKinds maps the kinds of records with a change log to their
+// collection.
+var historyKinds = map[string]string{
+	"resource": assetComputeRes,
+	"market":   assetMarket,
+}
+
+// untrackedFields change too often to be worth a log entry, e.g. on every
+// heartbeat flush. They are left out of diffs and state hashes.
+var untrackedFields = map[string][]string{
+	assetComputeRes: {"LastSeen"},
+}
+
+// tracked reports whether id is a resource or market element, with a change
+// log and index entries.
+func tracked(collection string, id string) bool {
+	if strings.HasPrefix(id, "\x00") {
+		// pools, change entries, indexes and other composite keys
+		return false
+	}
+	return collection == assetComputeRes || collection == assetMarket
+}
+
+func trackedFields(collection string, data []byte) (map[string]json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	if data == nil {
+		return fields, nil
+	}
+
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	for _, f := range untrackedFields[collection] {
+		delete(fields, f)
+	}
+
+	return fields, nil
+}
+
+// stateHash hashes the tracked fields of a record, json.Marshal sorts the
+// keys so the hash does not depend on field order.
+func stateHash(collection string, data []byte) (string, error) {
+	if data == nil {
+		return "", nil
+	}
+
+	fields, err := trackedFields(collection, data)
+	if err != nil {
+		return "", err
+	}
+
+	canonical, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func diffFields(collection string, before []byte, after []byte) ([]FieldChange, error) {
+	old, err := trackedFields(collection, before)
+	if err != nil {
+		return nil, err
+	}
+
+	cur, err := trackedFields(collection, after)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for f := range old {
+		names = append(names, f)
+	}
+	for f := range cur {
+		if _, ok := old[f]; !ok {
+			names = append(names, f)
+		}
+	}
+	sort.Strings(names)
+
+	diff := []FieldChange{}
+	for _, f := range names {
+		if bytes.Equal(old[f], cur[f]) {
+			continue
+		}
+		diff = append(diff, FieldChange{Field: f, From: string(old[f]), To: string(cur[f])})
+	}
+
+	return diff, nil
+}
+
+func (e *ChangeEntry) hash() (string, error) {
+	c := *e
+	c.Hash = ""
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func changeHeadKey(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(changeHeadKeyType, []string{id})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return key, nil
+}
+
+func (s *SmartContract) changeHead(ctx contractapi.TransactionContextInterface, collection string, id string) (*ChangeEntry, error) {
+	hid, err := changeHeadKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ctx.GetStub().GetPrivateData(collection, hid)
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil {
+		return nil, nil
+	}
+
+	var head ChangeEntry
+	err = json.Unmarshal(data, &head)
+	if err != nil {
+		return nil, err
+	}
+
+	return &head, nil
+}
+
+// recordChange appends an entry to the record's change log for replacing
+// before with data, nil for a delete.
+func (s *SmartContract) recordChange(ctx contractapi.TransactionContextInterface, collection string, id string, before []byte, data []byte) error {
+	diff, err := diffFields(collection, before, data)
+	if err != nil {
+		return err
+	}
+
+	if len(diff) == 0 {
+		return nil
+	}
+
+	head, err := s.changeHead(ctx, collection, id)
+	if err != nil {
+		return err
+	}
+
+	actor, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return err
+	}
+
+	msp, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return err
+	}
+
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+
+	sh, err := stateHash(collection, data)
+	if err != nil {
+		return err
+	}
+
+	e := ChangeEntry{
+		Seq:       1,
+		Record:    id,
+		Actor:     actor,
+		Msp:       msp,
+		TxId:      ctx.GetStub().GetTxID(),
+		Time:      int(_time.AsTime().UnixMicro()),
+		Diff:      diff,
+		StateHash: sh,
+	}
+
+	if head != nil {
+		e.Seq = head.Seq + 1
+		e.PrevHash = head.Hash
+	}
+
+	e.Hash, err = e.hash()
+	if err != nil {
+		return err
+	}
+
+	entry, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	eid, err := ctx.GetStub().CreateCompositeKey(changeKeyType, []string{id, fmt.Sprintf("%020d", e.Seq)})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	err = ctx.GetStub().PutPrivateData(collection, eid, entry)
+	if err != nil {
+		return err
+	}
+
+	hid, err := changeHeadKey(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutPrivateData(collection, hid, entry)
+}
+
+// historyCollection checks that the org may read the change log of a record
+// and returns the collection it is kept in. Resource logs are for the owner,
+// market logs for every member of the market.
+func (s *SmartContract) historyCollection(ctx contractapi.TransactionContextInterface, kind string, id string) (string, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	collection, ok := historyKinds[kind]
+	if !ok {
+		return "", fmt.Errorf("unknown record kind %s", kind)
+	}
+
+	if collection == assetComputeRes {
+		asset, err := s.readComputeRes(ctx, id)
+		if err != nil {
+			return "", err
+		}
+
+		if asset.OwnerOrg != org {
+			return "", fmt.Errorf("only owner can read the change history")
+		}
+	}
+
+	return collection, nil
+}
+
+func (s *SmartContract) changeEntries(ctx contractapi.TransactionContextInterface, collection string, id string) ([]*ChangeEntry, error) {
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(collection, changeKeyType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	entries := []*ChangeEntry{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var e ChangeEntry
+		err = json.Unmarshal(queryResponse.Value, &e)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &e)
+	}
+
+	return entries, nil
+}
+
+// GetChangeHistory returns the change log of a resource or market element,
+// oldest first. kind is "resource" or "market".
+func (s *SmartContract) GetChangeHistory(ctx contractapi.TransactionContextInterface, kind string, id string) ([]*ChangeEntry, error) {
+	collection, err := s.historyCollection(ctx, kind, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.changeEntries(ctx, collection, id)
+}
+
+// VerifyChangeHistory checks that every entry of the change log hashes to its
+// Hash, links to the one before, that the log ends at the recorded head and
+// that the head matches the record as it is stored now.
+func (s *SmartContract) VerifyChangeHistory(ctx contractapi.TransactionContextInterface, kind string, id string) (HistoryCheck, error) {
+	collection, err := s.historyCollection(ctx, kind, id)
+	if err != nil {
+		return HistoryCheck{}, err
+	}
+
+	entries, err := s.changeEntries(ctx, collection, id)
+	if err != nil {
+		return HistoryCheck{}, err
+	}
+
+	head, err := s.changeHead(ctx, collection, id)
+	if err != nil {
+		return HistoryCheck{}, err
+	}
+
+	check := HistoryCheck{Entries: len(entries)}
+	if head != nil {
+		check.Head = head.Hash
+	}
+
+	prev := ""
+	for i, e := range entries {
+		h, err := e.hash()
+		if err != nil {
+			return HistoryCheck{}, err
+		}
+
+		switch {
+		case e.Seq != i+1:
+			check.Error = fmt.Sprintf("entry %d is missing", i+1)
+		case e.Record != id:
+			check.Error = fmt.Sprintf("entry %d belongs to %s", e.Seq, e.Record)
+		case h != e.Hash:
+			check.Error = fmt.Sprintf("entry %d does not match its hash", e.Seq)
+		case e.PrevHash != prev:
+			check.Error = fmt.Sprintf("entry %d does not link to entry %d", e.Seq, e.Seq-1)
+		}
+
+		if check.Error != "" {
+			return check, nil
+		}
+
+		prev = e.Hash
+	}
+
+	if head == nil && len(entries) > 0 || head != nil && head.Hash != prev {
+		check.Error = "the log does not end at the recorded head"
+		return check, nil
+	}
+
+	current, err := ctx.GetStub().GetPrivateData(collection, id)
+	if err != nil {
+		return HistoryCheck{}, err
+	}
+
+	sh, err := stateHash(collection, current)
+	if err != nil {
+		return HistoryCheck{}, err
+	}
+
+	if head != nil && head.StateHash != sh {
+		check.Error = "the record was changed outside of the log"
+		return check, nil
+	}
+
+	check.Valid = true
+	return check, nil
+}
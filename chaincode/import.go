@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// ImportRow describes one machine of an inventory manifest. ExternalId is the
+// org's own stable name for the machine, importing the same ExternalId again
+// updates the resource created the first time. Spec is the expected hardware
+// and is replaced by the first report from setup.sh.
+type ImportRow struct {
+	ExternalId string            `json:"ExternalId"`
+	Name       string            `json:"Name"`
+	Labels     map[string]string `json:"Labels,omitempty" metadata:",optional"`
+	Spec       *HardwareSpec     `json:"Spec,omitempty" metadata:",optional"`
+}
+
+type ImportResult struct {
+	ExternalId string `json:"ExternalId"`
+	Id         string `json:"Id"`
+	Status     string `json:"Status"`
+	Error      string `json:"Error"`
+}
+
+const (
+	importCreated   = "created"
+	importUpdated   = "updated"
+	importUnchanged = "unchanged"
+	importFailed    = "failed"
+)
+
+// ImportComputeRes creates or updates one resource per row. Rows that fail
+// are reported and skipped, the others are committed. SSH details for the
+// rows are read from the "ssh" transient field, a JSON object of credential
+// envelopes keyed by ExternalId.
+func (s *SmartContract) ImportComputeRes(ctx contractapi.TransactionContextInterface, rows []ImportRow) ([]ImportResult, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, err
+	}
+
+	ssh := map[string]json.RawMessage{}
+	if s_r, ok := data["ssh"]; ok {
+		err = json.Unmarshal(s_r, &ssh)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ssh details: %v", err)
+		}
+	}
+
+	seen := map[string]bool{}
+	results := []ImportResult{}
+	for _, row := range rows {
+		res := ImportResult{ExternalId: row.ExternalId}
+
+		if seen[row.ExternalId] {
+			res.Status = importFailed
+			res.Error = "duplicate external id in the manifest"
+		} else {
+			seen[row.ExternalId] = true
+
+			res.Id, res.Status, err = s.importRow(ctx, org, row, ssh[row.ExternalId])
+			if err != nil {
+				res.Status = importFailed
+				res.Error = err.Error()
+			}
+		}
+
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+func (s *SmartContract) importRow(ctx contractapi.TransactionContextInterface, org string, row ImportRow, ssh json.RawMessage) (string, string, error) {
+	if !labelKeyPattern.MatchString(row.ExternalId) {
+		return "", "", fmt.Errorf("invalid external id %q", row.ExternalId)
+	}
+
+	for k, v := range row.Labels {
+		if !labelKeyPattern.MatchString(k) {
+			return "", "", fmt.Errorf("invalid label key %q", k)
+		}
+		if v == "" || !labelValuePattern.MatchString(v) {
+			return "", "", fmt.Errorf("invalid value %q for label %s", v, k)
+		}
+	}
+
+	if row.Spec != nil {
+		if err := row.Spec.validate(); err != nil {
+			return "", "", fmt.Errorf("invalid hardware spec: %v", err)
+		}
+	}
+
+	var envelope *CredentialEnvelope
+	if ssh != nil {
+		var err error
+		envelope, err = parseEnvelope(ssh, org)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	existing, err := indexedIds(ctx, assetComputeRes, externalIndex, org, row.ExternalId)
+	if err != nil {
+		return "", "", err
+	}
+
+	status := importUnchanged
+	var asset *ComputeRes
+
+	if len(existing) == 0 {
+		status = importCreated
+		asset = &ComputeRes{
+			Id:         ctx.GetStub().GetTxID() + "." + row.ExternalId,
+			Name:       row.Name,
+			State:      stateRegistered,
+			OwnerOrg:   org,
+			UserOrg:    org,
+			ExternalId: row.ExternalId,
+		}
+	} else {
+		asset, err = s.readComputeRes(ctx, existing[0])
+		if err != nil {
+			return "", "", err
+		}
+
+		if asset.State == stateDecommissioned {
+			return asset.Id, "", fmt.Errorf("the resource %s is %s", asset.Id, asset.State)
+		}
+	}
+
+	before, err := json.Marshal(asset)
+	if err != nil {
+		return "", "", err
+	}
+
+	if row.Name != "" {
+		asset.Name = row.Name
+	}
+
+	if row.Labels != nil {
+		asset.Labels = row.Labels
+	}
+
+	// reported hardware wins over the manifest
+	if row.Spec != nil && asset.State == stateRegistered {
+		asset.Spec = *row.Spec
+	}
+
+	after, err := json.Marshal(asset)
+	if err != nil {
+		return "", "", err
+	}
+
+	if status == importUnchanged && !bytes.Equal(before, after) {
+		status = importUpdated
+	}
+
+	if envelope != nil {
+		err = s.putCredentials(ctx, asset, org, asset.Id, envelope)
+		if err != nil {
+			return asset.Id, "", err
+		}
+
+		if status == importUnchanged {
+			status = importUpdated
+		}
+	}
+
+	if status == importUnchanged {
+		return asset.Id, status, nil
+	}
+
+	return asset.Id, status, s.putComputeRes(ctx, asset.Id, asset)
+}
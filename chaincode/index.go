@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// Secondary indexes live next to the records they point to as composite keys
+// with an empty value, the last attribute is always the record id. Index
+// updates diff against the committed record, so a record written twice in one
+// transaction may leave a stale entry behind; readers check every record
+// against the index and RebuildIndexes drops such entries.
+const (
+	ownerIndex  = "owner~res"
+	userIndex   = "user~res"
+	statusIndex = "status~market"
+
+	// externalIndex finds a resource by the id its owner imported it under
+	externalIndex = "ext~res"
+)
+
+var indexTypes = map[string][]string{
+	assetComputeRes: {ownerIndex, userIndex, externalIndex},
+	assetMarket:     {statusIndex},
+}
+
+// listedStatuses are the statuses ListMarketElements returns.
+var listedStatuses = []string{"open", "locked"}
+
+var indexValue = []byte{0x00}
+
+// indexKeys returns the index entries a record should have.
+func indexKeys(ctx contractapi.TransactionContextInterface, collection string, id string, data []byte) (map[string]bool, error) {
+	keys := map[string]bool{}
+	if data == nil {
+		return keys, nil
+	}
+
+	var attrs [][]string
+	var types []string
+
+	switch collection {
+	case assetComputeRes:
+		var c ComputeRes
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, err
+		}
+
+		types = append(types, ownerIndex, userIndex)
+		attrs = append(attrs, []string{c.OwnerOrg, id}, []string{c.UserOrg, id})
+
+		if c.ExternalId != "" {
+			types = append(types, externalIndex)
+			attrs = append(attrs, []string{c.OwnerOrg, c.ExternalId, id})
+		}
+
+		// slice renters rent the machine too
+		for _, l := range c.Leases {
+			if l.Status == leaseActive {
+				types = append(types, userIndex)
+				attrs = append(attrs, []string{l.Org, id})
+			}
+		}
+
+	case assetMarket:
+		var m ResMarket
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+
+		types = append(types, statusIndex)
+		attrs = append(attrs, []string{m.Status, id})
+	}
+
+	for i := range types {
+		key, err := ctx.GetStub().CreateCompositeKey(types[i], attrs[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to create composite key: %v", err)
+		}
+		keys[key] = true
+	}
+
+	return keys, nil
+}
+
+// updateIndexes moves a record's index entries from the ones of before to the
+// ones of data, nil for a missing record.
+func (s *SmartContract) updateIndexes(ctx contractapi.TransactionContextInterface, collection string, id string, before []byte, data []byte) error {
+	old, err := indexKeys(ctx, collection, id, before)
+	if err != nil {
+		return err
+	}
+
+	cur, err := indexKeys(ctx, collection, id, data)
+	if err != nil {
+		return err
+	}
+
+	for key := range old {
+		if cur[key] {
+			continue
+		}
+		if err := ctx.GetStub().DelPrivateData(collection, key); err != nil {
+			return err
+		}
+	}
+
+	for key := range cur {
+		if old[key] {
+			continue
+		}
+		if err := ctx.GetStub().PutPrivateData(collection, key, indexValue); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// indexedIds returns the ids of the records indexed under attrs, in key order.
+func indexedIds(ctx contractapi.TransactionContextInterface, collection string, index string, attrs ...string) ([]string, error) {
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(collection, index, attrs)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	ids := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, parts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, parts[len(parts)-1])
+	}
+
+	return ids, nil
+}
+
+// listIndexedComputeRes reads the resources indexed under org, redacted for
+// org. keep filters them before redaction.
+func (s *SmartContract) listIndexedComputeRes(ctx contractapi.TransactionContextInterface, org string, index string, keep func(c *ComputeRes) bool) ([]*ComputeRes, error) {
+	indexed := func(c *ComputeRes) bool {
+		if index == ownerIndex {
+			return c.OwnerOrg == org
+		}
+		return c.UserOrg == org || c.hasLease(org)
+	}
+
+	ids, err := indexedIds(ctx, assetComputeRes, index, org)
+	if err != nil {
+		return nil, err
+	}
+
+	assets := []*ComputeRes{}
+	for _, id := range ids {
+		asset, err := s.readComputeRes(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if !indexed(asset) || keep != nil && !keep(asset) {
+			continue
+		}
+
+		asset.redactListing(org)
+		assets = append(assets, asset)
+	}
+
+	return assets, nil
+}
+
+// ListOwnedComputeRes returns the org's own machines.
+func (s *SmartContract) ListOwnedComputeRes(ctx contractapi.TransactionContextInterface) ([]*ComputeRes, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.listIndexedComputeRes(ctx, org, ownerIndex, nil)
+}
+
+// ListRentedComputeRes returns the machines the org rents, whole or in
+// slices, from other orgs.
+func (s *SmartContract) ListRentedComputeRes(ctx contractapi.TransactionContextInterface) ([]*ComputeRes, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.listIndexedComputeRes(ctx, org, userIndex, func(c *ComputeRes) bool {
+		return c.OwnerOrg != org
+	})
+}
+
+// ListMarketElementsByStatus returns the market elements with status, e.g.
+// the open listings.
+func (s *SmartContract) ListMarketElementsByStatus(ctx contractapi.TransactionContextInterface, status string) ([]*ResMarket, error) {
+	ids, err := indexedIds(ctx, assetMarket, statusIndex, status)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []*ResMarket{}
+	for _, id := range ids {
+		element, err := s.getResMarketElement(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if element.Status != status {
+			continue
+		}
+
+		res = append(res, element)
+	}
+
+	return res, nil
+}
+
+// RebuildIndexes rewrites the index entries of every resource and market
+// element and drops stale ones. InitLedger does the same when the chaincode
+// is upgraded. It returns the number of records indexed.
+func (s *SmartContract) RebuildIndexes(ctx contractapi.TransactionContextInterface) (int, error) {
+	if _, err := verifyClientOrgMatchesPeerOrg(ctx); err != nil {
+		return 0, err
+	}
+
+	return s.rebuildIndexes(ctx)
+}
+
+func (s *SmartContract) rebuildIndexes(ctx contractapi.TransactionContextInterface) (int, error) {
+	indexed := 0
+	for collection, types := range indexTypes {
+		want := map[string]bool{}
+
+		resultsIterator, err := ctx.GetStub().GetPrivateDataByRange(collection, "", "")
+		if err != nil {
+			return indexed, err
+		}
+
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return indexed, err
+			}
+
+			keys, err := indexKeys(ctx, collection, queryResponse.Key, queryResponse.Value)
+			if err != nil {
+				resultsIterator.Close()
+				return indexed, err
+			}
+
+			for key := range keys {
+				want[key] = true
+			}
+			indexed++
+		}
+		resultsIterator.Close()
+
+		for _, index := range types {
+			stale, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(collection, index, []string{})
+			if err != nil {
+				return indexed, err
+			}
+
+			for stale.HasNext() {
+				queryResponse, err := stale.Next()
+				if err != nil {
+					stale.Close()
+					return indexed, err
+				}
+
+				if want[queryResponse.Key] {
+					delete(want, queryResponse.Key)
+					continue
+				}
+
+				if err := ctx.GetStub().DelPrivateData(collection, queryResponse.Key); err != nil {
+					stale.Close()
+					return indexed, err
+				}
+			}
+			stale.Close()
+		}
+
+		for key := range want {
+			if err := ctx.GetStub().PutPrivateData(collection, key, indexValue); err != nil {
+				return indexed, err
+			}
+		}
+	}
+
+	return indexed, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_./-]{0,61}[A-Za-z0-9])?$`)
+
+var labelValuePattern = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9_.-]{0,61}[A-Za-z0-9])?)?$`)
+
+// SetLabels merges labels into the resource's labels. An empty value removes
+// the label.
+func (s *SmartContract) SetLabels(ctx contractapi.TransactionContextInterface, id string, labels map[string]string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	asset, err := s.readComputeRes(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if asset.OwnerOrg != org {
+		return fmt.Errorf("only owner can label a compute resource")
+	}
+
+	if asset.Labels == nil {
+		asset.Labels = make(map[string]string)
+	}
+
+	for k, v := range labels {
+		if !labelKeyPattern.MatchString(k) {
+			return fmt.Errorf("invalid label key %q", k)
+		}
+		if !labelValuePattern.MatchString(v) {
+			return fmt.Errorf("invalid value %q for label %s", v, k)
+		}
+
+		if v == "" {
+			delete(asset.Labels, k)
+		} else {
+			asset.Labels[k] = v
+		}
+	}
+
+	return s.putComputeRes(ctx, id, asset)
+}
+
+// labelRequirement is one term of a selector such as "rack=a1", "team!=ml",
+// "gpu-class" (the label exists) or "!spot" (the label does not exist).
+type labelRequirement struct {
+	key    string
+	op     string
+	values []string
+}
+
+type labelSelector []labelRequirement
+
+// parseSelector reads comma separated requirements. "key in (a,b)" and
+// "key notin (a,b)" match a set of values.
+func parseSelector(selector string) (labelSelector, error) {
+	var sel labelSelector
+
+	for _, term := range splitSelector(selector) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		var r labelRequirement
+
+		switch {
+		case strings.Contains(term, "!="):
+			kv := strings.SplitN(term, "!=", 2)
+			r = labelRequirement{key: kv[0], op: "!=", values: []string{kv[1]}}
+		case strings.Contains(term, "="):
+			kv := strings.SplitN(strings.Replace(term, "==", "=", 1), "=", 2)
+			r = labelRequirement{key: kv[0], op: "=", values: []string{kv[1]}}
+		case strings.HasPrefix(term, "!"):
+			r = labelRequirement{key: term[1:], op: "!"}
+		case strings.Contains(term, " in ") || strings.Contains(term, " notin "):
+			op := "in"
+			if strings.Contains(term, " notin ") {
+				op = "notin"
+			}
+			kv := strings.SplitN(term, " "+op+" ", 2)
+			set := strings.TrimSpace(kv[1])
+			if !strings.HasPrefix(set, "(") || !strings.HasSuffix(set, ")") {
+				return nil, fmt.Errorf("invalid selector term %q", term)
+			}
+			r = labelRequirement{key: kv[0], op: op}
+			for _, v := range strings.Split(set[1:len(set)-1], ",") {
+				r.values = append(r.values, strings.TrimSpace(v))
+			}
+		default:
+			r = labelRequirement{key: term, op: "exists"}
+		}
+
+		r.key = strings.TrimSpace(r.key)
+		if !labelKeyPattern.MatchString(r.key) {
+			return nil, fmt.Errorf("invalid selector term %q", term)
+		}
+		for i := range r.values {
+			r.values[i] = strings.TrimSpace(r.values[i])
+		}
+
+		sel = append(sel, r)
+	}
+
+	return sel, nil
+}
+
+// splitSelector splits on the commas that are not inside a set.
+func splitSelector(selector string) []string {
+	var terms []string
+	depth, start := 0, 0
+
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(terms, selector[start:])
+}
+
+func (sel labelSelector) matches(labels map[string]string) bool {
+	for _, r := range sel {
+		v, ok := labels[r.key]
+
+		switch r.op {
+		case "exists":
+			if !ok {
+				return false
+			}
+		case "!":
+			if ok {
+				return false
+			}
+		case "=", "in":
+			if !ok || !contains(v, r.values) {
+				return false
+			}
+		case "!=", "notin":
+			if ok && contains(v, r.values) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const (
+	leaseListed = "listed"
+	leaseActive = "active"
+)
+
+// Capacity is an amount of a resource's divisible units. Memory is in bytes.
+type Capacity struct {
+	Gpus   int `json:"gpus"`
+	Cores  int `json:"cores"`
+	Memory int `json:"memory"`
+}
+
+func (c Capacity) isZero() bool {
+	return c == Capacity{}
+}
+
+func (c Capacity) add(o Capacity) Capacity {
+	return Capacity{Gpus: c.Gpus + o.Gpus, Cores: c.Cores + o.Cores, Memory: c.Memory + o.Memory}
+}
+
+// fits reports whether c is no larger than o in every unit.
+func (c Capacity) fits(o Capacity) bool {
+	return c.Gpus <= o.Gpus && c.Cores <= o.Cores && c.Memory <= o.Memory
+}
+
+// Lease is a slice of a resource offered on, or rented through, the market.
+// Its Id is the id of the market element, credentials for the renter are
+// stored under it in the renter's implicit collection.
+type Lease struct {
+	Id     string   `json:"Id"`
+	Status string   `json:"Status"`
+	Units  Capacity `json:"Units"`
+
+	Org     string `json:"Org"`
+	DueDate int    `json:"DueDate"`
+}
+
+func (c *ComputeRes) capacity() Capacity {
+	return Capacity{Gpus: len(c.Spec.Gpus), Cores: c.Spec.Cores, Memory: c.Spec.Memory}
+}
+
+// allocated sums the units of all listed and active leases.
+func (c *ComputeRes) allocated() Capacity {
+	var a Capacity
+	for _, l := range c.Leases {
+		a = a.add(l.Units)
+	}
+	return a
+}
+
+func (c *ComputeRes) lease(id string) *Lease {
+	for i := range c.Leases {
+		if c.Leases[i].Id == id {
+			return &c.Leases[i]
+		}
+	}
+	return nil
+}
+
+func (c *ComputeRes) removeLease(id string) {
+	leases := []Lease{}
+	for _, l := range c.Leases {
+		if l.Id != id {
+			leases = append(leases, l)
+		}
+	}
+	c.Leases = leases
+}
+
+// activeLease returns the org's current lease on the resource, if any.
+func (c *ComputeRes) activeLease(org string, now int) *Lease {
+	for i := range c.Leases {
+		l := &c.Leases[i]
+		if l.Status == leaseActive && l.Org == org && l.DueDate > now {
+			return l
+		}
+	}
+	return nil
+}
+
+func (c *ComputeRes) hasLease(org string) bool {
+	for _, l := range c.Leases {
+		if l.Status == leaseActive && l.Org == org {
+			return true
+		}
+	}
+	return false
+}
+
+// PutSliceOnMarket offers part of a resource's capacity. Several slices of
+// the same resource can be listed and rented by different orgs at once.
+func (s *SmartContract) PutSliceOnMarket(ctx contractapi.TransactionContextInterface, asset_id string, duration int, price int, units Capacity) (string, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	asset, err := s.readComputeRes(ctx, asset_id)
+	if err != nil {
+		return "", err
+	}
+
+	if asset.OwnerOrg != org {
+		return "", fmt.Errorf("only owner can market a res")
+	}
+
+	if err := asset.requireState(stateAvailable); err != nil {
+		return "", err
+	}
+
+	if asset.offline() {
+		return "", fmt.Errorf("the resource %s is offline", asset_id)
+	}
+
+	if units.isZero() || units.Gpus < 0 || units.Cores < 0 || units.Memory < 0 {
+		return "", fmt.Errorf("invalid units %+v", units)
+	}
+
+	if err := s.requireNoMaintenance(ctx, asset, duration); err != nil {
+		return "", err
+	}
+
+	free := asset.capacity()
+	alloc := asset.allocated()
+	if !units.add(alloc).fits(free) {
+		return "", fmt.Errorf("only %d gpus, %d cores and %d bytes of memory are free", free.Gpus-alloc.Gpus, free.Cores-alloc.Cores, free.Memory-alloc.Memory)
+	}
+
+	id := ctx.GetStub().GetTxID()
+
+	listing := *asset
+	listing.AccessLogs = nil
+	listing.StateHistory = nil
+	listing.Leases = nil
+
+	asset.Leases = append(asset.Leases, Lease{
+		Id:     id,
+		Status: leaseListed,
+		Units:  units,
+	})
+
+	err = s.putComputeRes(ctx, asset_id, asset)
+	if err != nil {
+		return "", err
+	}
+
+	return s.putOnMarket(ctx, ResMarket{
+		Id:       id,
+		Status:   "open",
+		Res:      listing,
+		Price:    price,
+		Duration: duration,
+		Units:    units,
+		OwnerOrg: org,
+	})
+}
+
+// endLease hands a rented slice over to the winner of its market element.
+// The owner's credentials give the whole machine, so a slice takes its own
+// in the "lease" transient field and the "ssh" field is never used for it.
+func (s *SmartContract) endLease(ctx contractapi.TransactionContextInterface, res *ResMarket, asset *ComputeRes) error {
+	l := asset.lease(res.Id)
+	if l == nil || l.Status != leaseListed {
+		return fmt.Errorf("the slice %s is not listed", res.Id)
+	}
+
+	err := s.handOverCredentials(ctx, asset, "lease", res.Winner, l.Id)
+	if err != nil {
+		return err
+	}
+
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+
+	l.Status = leaseActive
+	l.Org = res.Winner
+	l.DueDate = int(_time.AsTime().Add(time.Duration(res.Duration * int(time.Microsecond))).UnixMicro())
+
+	return s.putComputeRes(ctx, asset.Id, asset)
+}
+
+// claimLeases ends every expired slice lease of the resource.
+func (s *SmartContract) claimLeases(ctx contractapi.TransactionContextInterface, asset *ComputeRes) (int, error) {
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return 0, err
+	}
+	now := int(_time.AsTime().UnixMicro())
+
+	claimed := 0
+	for _, l := range asset.Leases {
+		if l.Status != leaseActive || l.DueDate > now {
+			continue
+		}
+
+		// the renter's copy of the credentials goes away with the lease
+		err = s.delCredentials(ctx, asset, l.Org, l.Id)
+		if err != nil {
+			return claimed, err
+		}
+
+		asset.removeLease(l.Id)
+		claimed++
+	}
+
+	return claimed, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const (
+	stateRegistered     = "registered"
+	stateProvisioning   = "provisioning"
+	stateAvailable      = "available"
+	stateListed         = "listed"
+	stateRented         = "rented"
+	stateMaintenance    = "maintenance"
+	stateOffline        = "offline"
+	stateDecommissioned = "decommissioned"
+)
+
+// stateTransitions lists the states each resource state may move to.
+var stateTransitions = map[string][]string{
+	stateRegistered:     {stateProvisioning, stateAvailable, stateDecommissioned},
+	stateProvisioning:   {stateAvailable, stateOffline, stateDecommissioned},
+	stateAvailable:      {stateListed, stateProvisioning, stateMaintenance, stateOffline, stateDecommissioned},
+	stateListed:         {stateAvailable, stateRented},
+	stateRented:         {stateAvailable},
+	stateMaintenance:    {stateAvailable, stateOffline, stateDecommissioned},
+	stateOffline:        {stateAvailable, stateProvisioning, stateMaintenance, stateDecommissioned},
+	stateDecommissioned: {},
+}
+
+// manualStates are the states an owner may set directly with
+// SetComputeResState, the others are driven by the market and DelComputeRes.
+var manualStates = []string{stateProvisioning, stateAvailable, stateMaintenance, stateOffline}
+
+// legacyStates maps the free form states of older records.
+var legacyStates = map[string]string{
+	"":              stateRegistered,
+	"uninitialized": stateRegistered,
+	"normal":        stateAvailable,
+}
+
+type StateChange struct {
+	From   string `json:"From"`
+	To     string `json:"To"`
+	Time   int    `json:"Time"`
+	By     string `json:"By"`
+	Reason string `json:"Reason"`
+}
+
+// normalizeState maps records written before the state machine existed.
+func (c *ComputeRes) normalizeState() {
+	st, ok := legacyStates[c.State]
+	if !ok {
+		return
+	}
+
+	if c.UserOrg != c.OwnerOrg {
+		st = stateRented
+	}
+
+	c.State = st
+}
+
+func (c *ComputeRes) canTransition(to string) bool {
+	return contains(to, stateTransitions[c.State])
+}
+
+// transition moves the resource to the given state and records who did it and why.
+func (s *SmartContract) transition(ctx contractapi.TransactionContextInterface, c *ComputeRes, to string, reason string) error {
+	if !c.canTransition(to) {
+		return fmt.Errorf("the resource %s can't go from %s to %s", c.Id, c.State, to)
+	}
+
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+
+	by, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.StateHistory = append(c.StateHistory, StateChange{
+		From:   c.State,
+		To:     to,
+		Time:   int(_time.AsTime().UnixMicro()),
+		By:     by,
+		Reason: reason,
+	})
+	c.State = to
+
+	return nil
+}
+
+// requireState fails unless the resource is in one of the given states.
+func (c *ComputeRes) requireState(states ...string) error {
+	if !contains(c.State, states) {
+		return fmt.Errorf("the resource %s is %s", c.Id, c.State)
+	}
+	return nil
+}
+
+func (s *SmartContract) SetComputeResState(ctx contractapi.TransactionContextInterface, id string, state string, reason string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	asset, err := s.readComputeRes(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if asset.OwnerOrg != org {
+		return fmt.Errorf("only owner can change the state of a compute resource")
+	}
+
+	if !contains(state, manualStates) {
+		return fmt.Errorf("the state %s can't be set directly", state)
+	}
+
+	if err := asset.requireState(stateRegistered, stateProvisioning, stateAvailable, stateMaintenance, stateOffline); err != nil {
+		return err
+	}
+
+	if len(asset.Leases) > 0 {
+		return fmt.Errorf("the resource %s has slices on the market or rented", id)
+	}
+
+	err = s.transition(ctx, asset, state, reason)
+	if err != nil {
+		return err
+	}
+
+	return s.putComputeRes(ctx, id, asset)
+}
+
+func (s *SmartContract) GetComputeResHistory(ctx contractapi.TransactionContextInterface, id string) ([]StateChange, error) {
+	asset, err := s.viewComputeRes(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if asset.StateHistory == nil {
+		return []StateChange{}, nil
+	}
+
+	return asset.StateHistory, nil
+}
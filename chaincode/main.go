@@ -8,7 +8,7 @@ import (
 )
 
 func main() {
-	assetChaincode, err := contractapi.NewChaincode(&SmartContract{})
+	assetChaincode, err := contractapi.NewChaincode(newSmartContract())
 	if err != nil {
 		log.Panicf("Error creating chaincode: %v", err)
 	}
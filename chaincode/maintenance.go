@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+type MaintenanceWindow struct {
+	Id     string `json:"Id"`
+	Start  int    `json:"Start"`
+	End    int    `json:"End"`
+	Reason string `json:"Reason"`
+}
+
+// maintenanceDuring returns the first window overlapping [start, end).
+func (c *ComputeRes) maintenanceDuring(start int, end int) *MaintenanceWindow {
+	for i := range c.Maintenance {
+		w := &c.Maintenance[i]
+		if w.Start < end && start < w.End {
+			return w
+		}
+	}
+	return nil
+}
+
+// requireNoMaintenance fails when a rental of duration starting now would
+// overlap a maintenance window.
+func (s *SmartContract) requireNoMaintenance(ctx contractapi.TransactionContextInterface, c *ComputeRes, duration int) error {
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+	now := int(_time.AsTime().UnixMicro())
+
+	if w := c.maintenanceDuring(now, now+duration); w != nil {
+		return fmt.Errorf("the rental overlaps the maintenance of %s from %s to %s: %s", c.Id,
+			time.UnixMicro(int64(w.Start)).UTC().Format(time.RFC3339), time.UnixMicro(int64(w.End)).UTC().Format(time.RFC3339), w.Reason)
+	}
+
+	return nil
+}
+
+// pruneMaintenance drops windows that are over.
+func (c *ComputeRes) pruneMaintenance(now int) {
+	windows := []MaintenanceWindow{}
+	for _, w := range c.Maintenance {
+		if w.End > now {
+			windows = append(windows, w)
+		}
+	}
+	c.Maintenance = windows
+}
+
+func (s *SmartContract) ScheduleMaintenance(ctx contractapi.TransactionContextInterface, id string, start int, end int, reason string) (string, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	asset, err := s.readComputeRes(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	if asset.OwnerOrg != org {
+		return "", fmt.Errorf("only owner can schedule maintenance")
+	}
+
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return "", err
+	}
+	now := int(_time.AsTime().UnixMicro())
+
+	if end <= start || end <= now {
+		return "", fmt.Errorf("the maintenance window must end after it starts and in the future")
+	}
+
+	asset.pruneMaintenance(now)
+
+	wid := ctx.GetStub().GetTxID()
+	asset.Maintenance = append(asset.Maintenance, MaintenanceWindow{
+		Id:     wid,
+		Start:  start,
+		End:    end,
+		Reason: reason,
+	})
+
+	return wid, s.putComputeRes(ctx, id, asset)
+}
+
+func (s *SmartContract) CancelMaintenance(ctx contractapi.TransactionContextInterface, id string, window string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	asset, err := s.readComputeRes(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if asset.OwnerOrg != org {
+		return fmt.Errorf("only owner can cancel maintenance")
+	}
+
+	windows := []MaintenanceWindow{}
+	for _, w := range asset.Maintenance {
+		if w.Id != window {
+			windows = append(windows, w)
+		}
+	}
+
+	if len(windows) == len(asset.Maintenance) {
+		return fmt.Errorf("the maintenance window %s does not exist", window)
+	}
+
+	asset.Maintenance = windows
+
+	return s.putComputeRes(ctx, id, asset)
+}
@@ -12,6 +12,9 @@ type BuyerInfo struct {
 	Org   string `json:"org"`
 	Price int    `json:"price"`
 	Date  int    `json:"date"`
+
+	// Held is the credit in escrow for the bid
+	Held int `json:"held"`
 }
 
 type ResMarket struct {
@@ -24,10 +27,26 @@ type ResMarket struct {
 	Duration   int        `json:"duration"`
 	MarketType string     `json:"type"`
 
+	// Units is the offered slice of the resource, zero for the whole machine
+	Units Capacity `json:"units"`
+
 	OwnerOrg string `json:"ownerOrg"`
 
 	Winner string               `json:"winner"`
 	Buyers map[string]BuyerInfo `json:"buyers"`
+
+	// Sealed elements take bid commitments until CloseTime, the bidders
+	// reveal them until RevealTime, see CommitBid
+	Sealed      bool                     `json:"sealed"`
+	CloseTime   int                      `json:"closeTime"`
+	RevealTime  int                      `json:"revealTime"`
+	Commitments map[string]BidCommitment `json:"commitments,omitempty" metadata:",optional"`
+
+	// Policy picks the winner once bidding closed, see SetAuctionTerms
+	Policy string `json:"policy"`
+
+	// Termination applies to the rental the element ends in
+	Termination TerminationRules `json:"termination"`
 }
 
 func (s *SmartContract) getResMarketElement(ctx contractapi.TransactionContextInterface, id string) (*ResMarket, error) {
@@ -59,12 +78,14 @@ func (s *SmartContract) putResMarketElement(ctx contractapi.TransactionContextIn
 
 func (s *SmartContract) putOnMarket(ctx contractapi.TransactionContextInterface, res ResMarket) (string, error) {
 
-	id := ctx.GetStub().GetTxID()
+	// several elements put on the market in one transaction bring their own ids
+	if res.Id == "" {
+		res.Id = ctx.GetStub().GetTxID()
+	}
+	id := res.Id
 
 	var err error
 
-	res.Id = id
-
 	if res.OwnerOrg == "" {
 		res.OwnerOrg, err = ctx.GetClientIdentity().GetMSPID()
 		if err != nil {
@@ -72,7 +93,7 @@ func (s *SmartContract) putOnMarket(ctx contractapi.TransactionContextInterface,
 		}
 	}
 	if res.MarketType == "" {
-		res.MarketType = "rent"
+		res.MarketType = marketRent
 	}
 
 	res.Status = "open"
@@ -114,8 +135,56 @@ func (s *SmartContract) RemoveFromMarket(ctx contractapi.TransactionContextInter
 
 	}
 
-	ctx.GetStub().DelPrivateData(assetMarket, id)
-	return nil
+	if res.Status == "open" {
+		_time, err := ctx.GetStub().GetTxTimestamp()
+		if err != nil {
+			return err
+		}
+		now := int(_time.AsTime().UnixMicro())
+
+		// the bidders may hold the owner to a closed auction, see SettleMarketElement
+		if res.CloseTime != 0 && now >= res.CloseTime && (len(res.Buyers) > 0 || res.Sealed && now < res.RevealTime && len(res.Commitments) > 0) {
+			return fmt.Errorf("bidding on %s closed with bids, it can't be removed", id)
+		}
+
+		err = settleBids(ctx, res, "")
+		if err != nil {
+			return err
+		}
+
+		asset, err := s.readComputeRes(ctx, res.Res.Id)
+		if err != nil {
+			return err
+		}
+
+		if !res.Units.isZero() {
+			asset.removeLease(id)
+
+			err = s.putComputeRes(ctx, asset.Id, asset)
+			if err != nil {
+				return err
+			}
+		} else if asset.State == stateListed {
+			err = s.transition(ctx, asset, stateAvailable, "removed from market")
+			if err != nil {
+				return err
+			}
+
+			err = s.putComputeRes(ctx, asset.Id, asset)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if res.MarketType == marketFixed {
+		err = s.delOffers(ctx, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.delState(ctx, assetMarket, id)
 }
 
 func (s *SmartContract) MakePrice(ctx contractapi.TransactionContextInterface, id string, price int) error {
@@ -135,6 +204,14 @@ func (s *SmartContract) MakePrice(ctx contractapi.TransactionContextInterface, i
 		return fmt.Errorf("the market status can't be modified %s", res.Status)
 	}
 
+	if res.Sealed {
+		return fmt.Errorf("bids on the sealed element %s are made with CommitBid", id)
+	}
+
+	if res.MarketType == marketFixed {
+		return fmt.Errorf("the element %s has a fixed price, use AcceptFixedPrice", id)
+	}
+
 	if res.OwnerOrg == org {
 		return fmt.Errorf("you can't make price on your own trades")
 	}
@@ -148,10 +225,26 @@ func (s *SmartContract) MakePrice(ctx contractapi.TransactionContextInterface, i
 		return err
 	}
 
+	if res.CloseTime != 0 && int(_time.AsTime().UnixMicro()) >= res.CloseTime {
+		return fmt.Errorf("bidding on %s closed at %s", id, time.UnixMicro(int64(res.CloseTime)).UTC().Format(time.RFC3339))
+	}
+
+	b := newCreditBatch(ctx)
+	err = b.hold(org, price-res.Buyers[org].Held, id)
+	if err != nil {
+		return err
+	}
+
+	err = b.commit()
+	if err != nil {
+		return err
+	}
+
 	res.Buyers[org] = BuyerInfo{
 		Org:   org,
 		Price: price,
 		Date:  int(_time.AsTime().UnixMicro()),
+		Held:  price,
 	}
 
 	return s.putResMarketElement(ctx, id, res)
@@ -164,6 +257,14 @@ func (s *SmartContract) LockMarketElement(ctx contractapi.TransactionContextInte
 		return err
 	}
 
+	if err := s.requireNoApproval(ctx, org, "LockMarketElement"); err != nil {
+		return err
+	}
+
+	return s.lockMarketElement(ctx, org, id, winner, price)
+}
+
+func (s *SmartContract) lockMarketElement(ctx contractapi.TransactionContextInterface, org string, id string, winner string, price int) error {
 	res, err := s.getResMarketElement(ctx, id)
 
 	if err != nil {
@@ -178,6 +279,18 @@ func (s *SmartContract) LockMarketElement(ctx contractapi.TransactionContextInte
 		return fmt.Errorf("can only lock a opening market element")
 	}
 
+	if res.MarketType == marketFixed {
+		return fmt.Errorf("the element %s goes to the first org to accept it", id)
+	}
+
+	if res.Policy != "" && res.Policy != policyOwner {
+		return fmt.Errorf("the winner of %s is picked by its %s policy, use SettleMarketElement", id, res.Policy)
+	}
+
+	if err := s.requireClosed(ctx, res); err != nil {
+		return err
+	}
+
 	res.Status = "locked"
 
 	by, ok := res.Buyers[winner]
@@ -202,7 +315,13 @@ func (s *SmartContract) PutOnMarket(ctx contractapi.TransactionContextInterface,
 		return "", err
 	}
 
-	asset, err := s.GetComputeRes(ctx, asset_id)
+	return s.putResOnMarket(ctx, org, asset_id, ResMarket{Price: price, Duration: duration})
+}
+
+// putResOnMarket lists a whole machine. element carries the terms of the
+// listing, the rest is filled in here.
+func (s *SmartContract) putResOnMarket(ctx contractapi.TransactionContextInterface, org string, asset_id string, element ResMarket) (string, error) {
+	asset, err := s.readComputeRes(ctx, asset_id)
 
 	if err != nil {
 		return "", err
@@ -212,17 +331,41 @@ func (s *SmartContract) PutOnMarket(ctx contractapi.TransactionContextInterface,
 		return "", fmt.Errorf("only owner can market a res")
 	}
 
-	asset.SSHAccessDetails = SSHAccessDetails{}
-	asset.AccessLogs = []Access{}
+	if err := asset.requireState(stateAvailable); err != nil {
+		return "", err
+	}
+
+	if asset.offline() {
+		return "", fmt.Errorf("the resource %s is offline", asset_id)
+	}
+
+	if len(asset.Leases) > 0 {
+		return "", fmt.Errorf("the resource %s has slices on the market or rented", asset_id)
+	}
+
+	if err := s.requireNoMaintenance(ctx, asset, element.Duration); err != nil {
+		return "", err
+	}
+
+	listing := *asset
+	listing.AccessLogs = nil
+	listing.StateHistory = nil
 
-	return s.putOnMarket(ctx, ResMarket{
-		Status:   "open",
-		Res:      *asset,
-		Price:    price,
-		Duration: duration,
-		OwnerOrg: org,
-		Winner:   "",
-	})
+	err = s.transition(ctx, asset, stateListed, "put on market")
+	if err != nil {
+		return "", err
+	}
+
+	err = s.putComputeRes(ctx, asset_id, asset)
+	if err != nil {
+		return "", err
+	}
+
+	element.Res = listing
+	element.OwnerOrg = org
+	element.Winner = ""
+
+	return s.putOnMarket(ctx, element)
 
 }
 
@@ -232,6 +375,15 @@ func (s *SmartContract) EndMarketElement(ctx contractapi.TransactionContextInter
 	if err != nil {
 		return err
 	}
+
+	if err := s.requireNoApproval(ctx, org, "EndMarketElement"); err != nil {
+		return err
+	}
+
+	return s.endMarketElement(ctx, org, id)
+}
+
+func (s *SmartContract) endMarketElement(ctx contractapi.TransactionContextInterface, org string, id string) error {
 	res, err := s.getResMarketElement(ctx, id)
 	if err != nil {
 		return err
@@ -245,7 +397,62 @@ func (s *SmartContract) EndMarketElement(ctx contractapi.TransactionContextInter
 		return fmt.Errorf("can only end a locked element")
 	}
 
-	compres, err := s.GetComputeRes(ctx, res.Res.Id)
+	return s.closeMarketElement(ctx, res)
+}
+
+// closeMarketElement hands a locked element over to its winner and ends it.
+func (s *SmartContract) closeMarketElement(ctx contractapi.TransactionContextInterface, res *ResMarket) error {
+	id := res.Id
+
+	compres, err := s.readComputeRes(ctx, res.Res.Id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.requireNoMaintenance(ctx, compres, res.Duration); err != nil {
+		return err
+	}
+
+	err = settleBids(ctx, res, res.Winner)
+	if err != nil {
+		return err
+	}
+
+	if res.MarketType == marketSell {
+		err = s.endSale(ctx, res, compres)
+		if err != nil {
+			return err
+		}
+
+		res.Status = "ended"
+
+		return s.putResMarketElement(ctx, id, res)
+	}
+
+	if !res.Units.isZero() {
+		err = s.endLease(ctx, res, compres)
+		if err != nil {
+			return err
+		}
+
+		res.Status = "ended"
+
+		return s.putResMarketElement(ctx, id, res)
+	}
+
+	err = s.handOverCredentials(ctx, compres, "ssh", res.Winner, compres.Id)
+	if err != nil {
+		return err
+	}
+
+	return s.rentOut(ctx, res, compres)
+}
+
+// rentOut rents the whole machine of an element to its winner for the
+// element's duration and ends the element. The winner's credentials must be
+// in place already.
+func (s *SmartContract) rentOut(ctx contractapi.TransactionContextInterface, res *ResMarket, compres *ComputeRes) error {
+	err := s.transition(ctx, compres, stateRented, "rented to "+res.Winner)
 	if err != nil {
 		return err
 	}
@@ -257,39 +464,27 @@ func (s *SmartContract) EndMarketElement(ctx contractapi.TransactionContextInter
 	}
 
 	compres.UserOrgDueDate = int(_time.AsTime().Add(time.Duration(res.Duration * int(time.Microsecond))).UnixMicro())
+	startRental(res, compres, int(_time.AsTime().UnixMicro()))
 
 	res.Status = "ended"
 
-	err = s.putResMarketElement(ctx, id, res)
+	err = s.putResMarketElement(ctx, res.Id, res)
 	if err != nil {
 		return err
 	}
 
-	return s.PutComputeRes(ctx, compres.Id, compres)
+	return s.putComputeRes(ctx, compres.Id, compres)
 }
 
 func (s *SmartContract) ListMarketElements(ctx contractapi.TransactionContextInterface) ([]*ResMarket, error) {
-	resultsIterator, err := ctx.GetStub().GetPrivateDataByRange(assetMarket, "", "")
-	if err != nil {
-		return nil, err
-	}
-	defer resultsIterator.Close()
-
 	var res []*ResMarket
-	for resultsIterator.HasNext() {
-		result, err := resultsIterator.Next()
+	for _, status := range listedStatuses {
+		elements, err := s.ListMarketElementsByStatus(ctx, status)
 		if err != nil {
 			return nil, err
 		}
 
-		var element ResMarket
-		err = json.Unmarshal(result.Value, &element)
-		if err != nil {
-			return nil, err
-		}
-		if element.Status != "ended" {
-			res = append(res, &element)
-		}
+		res = append(res, elements...)
 	}
 
 	return res, nil
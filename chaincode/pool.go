@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// Pool is a named group of an org's resources that can be granted, put on
+// the market and reclaimed as one unit.
+type Pool struct {
+	Name      string   `json:"Name"`
+	Org       string   `json:"Org"`
+	Resources []string `json:"Resources"`
+}
+
+func (s *SmartContract) getPool(ctx contractapi.TransactionContextInterface, org string, name string) (string, *Pool, error) {
+	pid, err := ctx.GetStub().CreateCompositeKey(poolKeyType, []string{org, name})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	state, err := s.readState(ctx, assetComputeRes, pid)
+	if err != nil {
+		return pid, nil, fmt.Errorf("the pool %s does not exist", name)
+	}
+
+	var p Pool
+	err = json.Unmarshal(state, &p)
+	if err != nil {
+		return pid, nil, err
+	}
+
+	return pid, &p, nil
+}
+
+// SetPool creates or replaces a pool. Every member must be owned by the org.
+func (s *SmartContract) SetPool(ctx contractapi.TransactionContextInterface, name string, resources []string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !labelKeyPattern.MatchString(name) {
+		return fmt.Errorf("invalid pool name %q", name)
+	}
+
+	members := []string{}
+	for _, id := range resources {
+		if contains(id, members) {
+			continue
+		}
+
+		asset, err := s.readComputeRes(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if asset.OwnerOrg != org {
+			return fmt.Errorf("%s is not owned by %s", id, org)
+		}
+
+		members = append(members, id)
+	}
+
+	pid, err := ctx.GetStub().CreateCompositeKey(poolKeyType, []string{org, name})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	data, err := json.Marshal(Pool{Name: name, Org: org, Resources: members})
+	if err != nil {
+		return err
+	}
+
+	return s.putState(ctx, assetComputeRes, pid, data)
+}
+
+func (s *SmartContract) DeletePool(ctx contractapi.TransactionContextInterface, name string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	pid, _, err := s.getPool(ctx, org, name)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelPrivateData(assetComputeRes, pid)
+}
+
+func (s *SmartContract) GetPool(ctx contractapi.TransactionContextInterface, name string) (Pool, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return Pool{}, err
+	}
+
+	_, p, err := s.getPool(ctx, org, name)
+	if err != nil {
+		return Pool{}, err
+	}
+
+	return *p, nil
+}
+
+func (s *SmartContract) ListPools(ctx contractapi.TransactionContextInterface) ([]*Pool, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(assetComputeRes, poolKeyType, []string{org})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	pools := []*Pool{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var p Pool
+		err = json.Unmarshal(queryResponse.Value, &p)
+		if err != nil {
+			return nil, err
+		}
+
+		pools = append(pools, &p)
+	}
+
+	return pools, nil
+}
+
+// ListPoolResources returns the pool's members that match the label selector.
+func (s *SmartContract) ListPoolResources(ctx contractapi.TransactionContextInterface, name string, selector string) ([]*ComputeRes, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sel, err := parseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	_, p, err := s.getPool(ctx, org, name)
+	if err != nil {
+		return nil, err
+	}
+
+	assets := []*ComputeRes{}
+	for _, id := range p.Resources {
+		asset, err := s.readComputeRes(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if !sel.matches(asset.Labels) {
+			continue
+		}
+
+		asset.redactListing(org)
+		assets = append(assets, asset)
+	}
+
+	return assets, nil
+}
+
+// GrantPoolAccess grants user access to every resource in the pool.
+func (s *SmartContract) GrantPoolAccess(ctx contractapi.TransactionContextInterface, user string, name string, expiry int) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, p, err := s.getPool(ctx, org, name)
+	if err != nil {
+		return err
+	}
+
+	return s.grantAccess(ctx, org, user, p.Resources, expiry)
+}
+
+// PutPoolOnMarket lists every available member of the pool with the same
+// terms and returns the ids of the new market elements.
+func (s *SmartContract) PutPoolOnMarket(ctx contractapi.TransactionContextInterface, name string, duration int, price int) ([]string, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, p, err := s.getPool(ctx, org, name)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, id := range p.Resources {
+		asset, err := s.readComputeRes(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if asset.State != stateAvailable || asset.offline() || len(asset.Leases) > 0 {
+			continue
+		}
+
+		if s.requireNoMaintenance(ctx, asset, duration) != nil {
+			continue
+		}
+
+		mid, err := s.putResOnMarket(ctx, org, id, ResMarket{
+			Id:       ctx.GetStub().GetTxID() + "." + id,
+			Price:    price,
+			Duration: duration,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, mid)
+	}
+
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no resource in the pool %s can be put on the market", name)
+	}
+
+	return ids, nil
+}
+
+// ClaimPoolRent reclaims every member of the pool whose rent is due and
+// returns their ids. The refunds of all of them are committed together, so
+// an org refunded for several members is written once.
+func (s *SmartContract) ClaimPoolRent(ctx contractapi.TransactionContextInterface, name string) ([]string, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, p, err := s.getPool(ctx, org, name)
+	if err != nil {
+		return nil, err
+	}
+
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	now := int(_time.AsTime().UnixMicro())
+
+	b := newCreditBatch(ctx)
+	ids := []string{}
+	for _, id := range p.Resources {
+		asset, err := s.readComputeRes(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		due := asset.State == stateRented && asset.UserOrgDueDate < now
+		for _, l := range asset.Leases {
+			if l.Status == leaseActive && l.DueDate <= now {
+				due = true
+			}
+		}
+
+		if !due {
+			continue
+		}
+
+		err = s.claimRent(ctx, b, org, id)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, b.commit()
+}
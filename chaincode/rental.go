@@ -0,0 +1,390 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// TerminationRules say whether a rental from a listing can end early. The
+// side ending it gives Notice microseconds of notice. The renter gets back
+// Refund percent of the price of the time left after the notice, the owner
+// always pays back all of it.
+type TerminationRules struct {
+	Allowed bool `json:"allowed"`
+	Notice  int  `json:"notice"`
+	Refund  int  `json:"refund"`
+}
+
+// Rental is the running whole machine rental of a resource and what has been
+// settled for it so far.
+type Rental struct {
+	Market      string             `json:"Market"`
+	Org         string             `json:"Org"`
+	Start       int                `json:"Start"`
+	Paid        int                `json:"Paid"`
+	Termination TerminationRules   `json:"Termination"`
+	Terminated  bool               `json:"Terminated"`
+	Extension   *ExtensionRequest  `json:"Extension,omitempty" metadata:",optional"`
+	Settlements []RentalSettlement `json:"Settlements"`
+}
+
+// ExtensionRequest is the renter's offer of Price for Duration more
+// microseconds. The price is held in escrow until the owner answers.
+type ExtensionRequest struct {
+	Duration  int `json:"Duration"`
+	Price     int `json:"Price"`
+	Requested int `json:"Requested"`
+}
+
+// RentalSettlement records a change of a rental. Amount is the credit paid
+// to the owner, negative for a refund, DueDate the due date after it.
+type RentalSettlement struct {
+	Kind    string `json:"Kind"`
+	By      string `json:"By"`
+	Time    int    `json:"Time"`
+	Amount  int    `json:"Amount"`
+	DueDate int    `json:"DueDate"`
+}
+
+const (
+	settleRent        = "rent"
+	settleExtension   = "extension"
+	settleTermination = "termination"
+)
+
+// SetTerminationRules lets rentals from an open element end early with
+// notice microseconds of notice and refund percent of the unused time
+// refunded to a renter who ends it. The rules can't change once there are
+// bids.
+func (s *SmartContract) SetTerminationRules(ctx contractapi.TransactionContextInterface, id string, notice int, refund int) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.getResMarketElement(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if res.OwnerOrg != org {
+		return fmt.Errorf("only the releaser can set the terms of a market element")
+	}
+
+	if res.Status != "open" {
+		return fmt.Errorf("the market status can't be modified %s", res.Status)
+	}
+
+	if res.MarketType == marketSell || !res.Units.isZero() {
+		return fmt.Errorf("only whole machine rentals can end early")
+	}
+
+	if len(res.Buyers) > 0 || len(res.Commitments) > 0 {
+		return fmt.Errorf("the terms of %s can't change once bids are made", id)
+	}
+
+	if notice < 0 {
+		return fmt.Errorf("the notice period can't be negative")
+	}
+
+	if refund < 0 || refund > 100 {
+		return fmt.Errorf("the refund must be a percentage")
+	}
+
+	res.Termination = TerminationRules{
+		Allowed: true,
+		Notice:  notice,
+		Refund:  refund,
+	}
+
+	return s.putResMarketElement(ctx, id, res)
+}
+
+// startRental records the rental of an element the winner just paid for.
+func startRental(res *ResMarket, compres *ComputeRes, now int) {
+	paid := res.Buyers[res.Winner].Price
+
+	compres.Rental = &Rental{
+		Market:      res.Id,
+		Org:         res.Winner,
+		Start:       now,
+		Paid:        paid,
+		Termination: res.Termination,
+		Settlements: []RentalSettlement{{
+			Kind:    settleRent,
+			By:      res.Winner,
+			Time:    now,
+			Amount:  paid,
+			DueDate: compres.UserOrgDueDate,
+		}},
+	}
+}
+
+// runningRental returns the resource and its rental if org is a party to it
+// and it is still running.
+func (s *SmartContract) runningRental(ctx contractapi.TransactionContextInterface, id string, org string) (*ComputeRes, int, error) {
+	asset, err := s.readComputeRes(ctx, id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if asset.OwnerOrg != org && asset.UserOrg != org {
+		return nil, 0, fmt.Errorf("%s is not rented by or from %s", id, org)
+	}
+
+	if err := asset.requireState(stateRented); err != nil {
+		return nil, 0, err
+	}
+
+	if asset.Rental == nil || asset.Rental.Org != asset.UserOrg {
+		return nil, 0, fmt.Errorf("the rental of %s has no terms to change", id)
+	}
+
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, 0, err
+	}
+	now := int(_time.AsTime().UnixMicro())
+
+	if now >= asset.UserOrgDueDate {
+		return nil, 0, fmt.Errorf("the rental of %s is over", id)
+	}
+
+	return asset, now, nil
+}
+
+// RequestExtension offers the owner price for duration more microseconds of
+// the org's rental. The price is held in escrow until the owner accepts or
+// either side declines.
+func (s *SmartContract) RequestExtension(ctx contractapi.TransactionContextInterface, id string, duration int, price int) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	asset, now, err := s.runningRental(ctx, id, org)
+	if err != nil {
+		return err
+	}
+
+	if asset.UserOrg != org {
+		return fmt.Errorf("only the renter can ask for an extension")
+	}
+
+	if asset.Rental.Terminated {
+		return fmt.Errorf("the rental of %s is being terminated", id)
+	}
+
+	if asset.Rental.Extension != nil {
+		return fmt.Errorf("an extension of %s is already requested", id)
+	}
+
+	if duration <= 0 || price < 0 {
+		return fmt.Errorf("invalid extension of %d for %d", duration, price)
+	}
+
+	b := newCreditBatch(ctx)
+	err = b.hold(org, price, id)
+	if err != nil {
+		return err
+	}
+
+	err = b.commit()
+	if err != nil {
+		return err
+	}
+
+	asset.Rental.Extension = &ExtensionRequest{
+		Duration:  duration,
+		Price:     price,
+		Requested: now,
+	}
+
+	return s.putComputeRes(ctx, id, asset)
+}
+
+// AcceptExtension takes the renter's offer: the held price goes to the owner
+// and the due date moves by the requested duration.
+func (s *SmartContract) AcceptExtension(ctx contractapi.TransactionContextInterface, id string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	asset, now, err := s.runningRental(ctx, id, org)
+	if err != nil {
+		return err
+	}
+
+	if asset.OwnerOrg != org {
+		return fmt.Errorf("only the owner can accept an extension")
+	}
+
+	ext := asset.Rental.Extension
+	if ext == nil {
+		return fmt.Errorf("no extension of %s is requested", id)
+	}
+
+	due := asset.UserOrgDueDate + ext.Duration
+	if err := s.requireNoMaintenance(ctx, asset, due-now); err != nil {
+		return err
+	}
+
+	b := newCreditBatch(ctx)
+	err = b.payHeld(asset.UserOrg, org, ext.Price, id)
+	if err != nil {
+		return err
+	}
+
+	err = b.commit()
+	if err != nil {
+		return err
+	}
+
+	asset.UserOrgDueDate = due
+	asset.Rental.Paid += ext.Price
+	asset.Rental.Extension = nil
+	asset.Rental.Settlements = append(asset.Rental.Settlements, RentalSettlement{
+		Kind:    settleExtension,
+		By:      org,
+		Time:    now,
+		Amount:  ext.Price,
+		DueDate: due,
+	})
+
+	return s.putComputeRes(ctx, id, asset)
+}
+
+// DeclineExtension drops the requested extension, by the owner or by the
+// renter withdrawing it, and refunds the held price.
+func (s *SmartContract) DeclineExtension(ctx contractapi.TransactionContextInterface, id string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	asset, _, err := s.runningRental(ctx, id, org)
+	if err != nil {
+		return err
+	}
+
+	if asset.Rental.Extension == nil {
+		return fmt.Errorf("no extension of %s is requested", id)
+	}
+
+	b := newCreditBatch(ctx)
+	err = b.hold(asset.UserOrg, -asset.Rental.Extension.Price, id)
+	if err != nil {
+		return err
+	}
+
+	err = b.commit()
+	if err != nil {
+		return err
+	}
+
+	asset.Rental.Extension = nil
+
+	return s.putComputeRes(ctx, id, asset)
+}
+
+// TerminateRental ends a rental early under the rules of its listing. The
+// rental ends after the notice period and the owner refunds the prorated
+// price of the time left then, in full when the owner terminates and the
+// listing's share when the renter does. A requested extension is declined.
+func (s *SmartContract) TerminateRental(ctx contractapi.TransactionContextInterface, id string) (RentalSettlement, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return RentalSettlement{}, err
+	}
+
+	asset, now, err := s.runningRental(ctx, id, org)
+	if err != nil {
+		return RentalSettlement{}, err
+	}
+
+	rental := asset.Rental
+	if !rental.Termination.Allowed {
+		return RentalSettlement{}, fmt.Errorf("the rental of %s can't end early", id)
+	}
+
+	if rental.Terminated {
+		return RentalSettlement{}, fmt.Errorf("the rental of %s is already terminated", id)
+	}
+
+	end := now + rental.Termination.Notice
+	if end >= asset.UserOrgDueDate {
+		return RentalSettlement{}, fmt.Errorf("the rental of %s ends at %s, before the notice period is over", id, time.UnixMicro(int64(asset.UserOrgDueDate)).UTC().Format(time.RFC3339))
+	}
+
+	refund := rental.terminationRefund(asset.UserOrgDueDate, end, org == asset.UserOrg)
+
+	b := newCreditBatch(ctx)
+	if rental.Extension != nil {
+		err = b.hold(asset.UserOrg, -rental.Extension.Price, id)
+		if err != nil {
+			return RentalSettlement{}, err
+		}
+		rental.Extension = nil
+	}
+
+	err = b.pay(asset.OwnerOrg, asset.UserOrg, refund, id)
+	if err != nil {
+		return RentalSettlement{}, err
+	}
+
+	err = b.commit()
+	if err != nil {
+		return RentalSettlement{}, err
+	}
+
+	settlement := RentalSettlement{
+		Kind:    settleTermination,
+		By:      org,
+		Time:    now,
+		Amount:  -refund,
+		DueDate: end,
+	}
+
+	asset.UserOrgDueDate = end
+	rental.Paid -= refund
+	rental.Terminated = true
+	rental.Settlements = append(rental.Settlements, settlement)
+
+	return settlement, s.putComputeRes(ctx, id, asset)
+}
+
+// terminationRefund is the price of the time left between end and the due
+// date, prorated over the whole rental. A renter ending it gets the listing's
+// share of that.
+func (r *Rental) terminationRefund(due int, end int, byRenter bool) int {
+	refund := new(big.Int).Mul(big.NewInt(int64(r.Paid)), big.NewInt(int64(due-end)))
+	refund.Quo(refund, big.NewInt(int64(due-r.Start)))
+	if byRenter {
+		refund.Mul(refund, big.NewInt(int64(r.Termination.Refund)))
+		refund.Quo(refund, big.NewInt(100))
+	}
+
+	return int(refund.Int64())
+}
+
+// endRental closes the rental record when the rent is claimed and refunds an
+// extension nobody answered in b.
+func endRental(b *creditBatch, asset *ComputeRes) error {
+	if asset.Rental == nil {
+		return nil
+	}
+
+	if ext := asset.Rental.Extension; ext != nil {
+		err := b.hold(asset.Rental.Org, -ext.Price, asset.Id)
+		if err != nil {
+			return err
+		}
+	}
+
+	asset.Rental = nil
+	return nil
+}
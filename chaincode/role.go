@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const (
+	permResCreate  = "resource:create"
+	permResUpdate  = "resource:update"
+	permResDelete  = "resource:delete"
+	permConnect    = "resource:connect"
+	permConnectAll = "resource:connect-all"
+	permLogsRead   = "logs:read"
+	permMarketList = "market:list"
+	permMarketBid  = "market:bid"
+	permUserManage = "user:manage"
+	permRoleManage = "role:manage"
+	permApprove    = "operation:approve"
+
+	permCreditMint     = "credit:mint"
+	permCreditTransfer = "credit:transfer"
+)
+
+var allPermissions = []string{
+	permResCreate,
+	permResUpdate,
+	permResDelete,
+	permConnect,
+	permConnectAll,
+	permLogsRead,
+	permMarketList,
+	permMarketBid,
+	permUserManage,
+	permRoleManage,
+	permApprove,
+	permCreditMint,
+	permCreditTransfer,
+}
+
+// defaultRoles are available in every org until the org overrides them on the
+// ledger. The "admin" role always holds every permission and can't be edited.
+var defaultRoles = map[string][]string{
+	"admin":            allPermissions,
+	"member":           {permConnect},
+	"resource-manager": {permResCreate, permResUpdate, permResDelete, permConnect, permConnectAll, permLogsRead},
+	"market-trader":    {permMarketList, permMarketBid, permCreditTransfer},
+	"operator":         {permResUpdate, permConnect, permConnectAll},
+	"auditor":          {permLogsRead},
+}
+
+// txPermissions maps each transaction to the permission the submitting user
+// needs. Transactions in neither txPermissions nor openTransactions are
+// refused.
+var txPermissions = map[string]string{
+	"CreateComputeRes": permResCreate,
+	"ImportComputeRes": permResCreate,
+	"UpdateComputeRes": permResUpdate,
+	"AssignUser":       permResUpdate,
+	"DelComputeRes":    permResDelete,
+
+	"SetComputeResState": permResUpdate,
+	"RebuildIndexes":     permResUpdate,
+	"ReportHeartbeats":   permResUpdate,
+	"MarkOffline":        permResUpdate,
+	"SetLabels":          permResUpdate,
+
+	"ScheduleMaintenance": permResUpdate,
+	"CancelMaintenance":   permResUpdate,
+
+	"SetPool":         permResUpdate,
+	"DeletePool":      permResUpdate,
+	"GrantPoolAccess": permUserManage,
+	"PutPoolOnMarket": permMarketList,
+	"ClaimPoolRent":   permMarketList,
+
+	"MigrateCredentials":    permResUpdate,
+	"ListLegacyCredentials": permResUpdate,
+	"GetCredentials":        permMarketList,
+
+	"GetConnectDetails": permConnect,
+	"GetConnectionLogs": permLogsRead,
+
+	"GetChangeHistory":    permLogsRead,
+	"VerifyChangeHistory": permLogsRead,
+
+	"PurgeConnectionLogs": permResDelete,
+	"MigrateAccessLogs":   permResUpdate,
+
+	"PutOnMarket":         permMarketList,
+	"PutSliceOnMarket":    permMarketList,
+	"SellOnMarket":        permMarketList,
+	"PutSealedOnMarket":   permMarketList,
+	"PutFixedOnMarket":    permMarketList,
+	"RemoveFromMarket":    permMarketList,
+	"LockMarketElement":   permMarketList,
+	"EndMarketElement":    permMarketList,
+	"SetAuctionTerms":     permMarketList,
+	"SettleMarketElement": permMarketList,
+	"ClaimRent":           permMarketList,
+	"MakePrice":           permMarketBid,
+	"CommitBid":           permMarketBid,
+	"RevealBid":           permMarketBid,
+	"AcceptFixedPrice":    permMarketBid,
+
+	"SetTerminationRules": permMarketList,
+	"RequestExtension":    permMarketBid,
+	"AcceptExtension":     permMarketList,
+	"DeclineExtension":    permMarketList,
+	"TerminateRental":     permMarketList,
+
+	"SetCreditIssuer":    permCreditMint,
+	"MintCredits":        permCreditMint,
+	"TransferCredits":    permCreditTransfer,
+	"GetCreditStatement": permLogsRead,
+
+	"AddUser":         permUserManage,
+	"RemoveUser":      permUserManage,
+	"SetUserRole":     permUserManage,
+	"SetUserDisabled": permUserManage,
+	"ListUsers":       permUserManage,
+	"GrantAccess":     permUserManage,
+	"RevokeAccess":    permUserManage,
+
+	"SetAccessPolicies": permResUpdate,
+
+	"SetRole":           permRoleManage,
+	"DeleteRole":        permRoleManage,
+	"SetApprovalPolicy": permRoleManage,
+	"SetOrgKey":         permRoleManage,
+
+	"ProposeOperation": permApprove,
+	"ApproveOperation": permApprove,
+}
+
+// openTransactions are open to any client of the org. They only read what the
+// org may see anyway, or authorize the caller themselves.
+var openTransactions = map[string]bool{
+	"CreateRootUser":              true,
+	"GetUserInfo":                 true,
+	"GetSubmittingClientIdentity": true,
+	"ListRoles":                   true,
+
+	"GetComputeRes":        true,
+	"GetComputeResHistory": true,
+	"ListComputeRes":       true,
+	"ListOwnedComputeRes":  true,
+	"ListRentedComputeRes": true,
+	"QueryComputeRes":      true,
+	"SearchComputeRes":     true,
+	"GetAccessPolicies":    true,
+
+	"GetPool":           true,
+	"ListPools":         true,
+	"ListPoolResources": true,
+
+	"GetMarketElement":           true,
+	"ListMarketElements":         true,
+	"ListMarketElementsByStatus": true,
+	"PickWinner":                 true,
+	"GetOrgKey":                  true,
+	"ListOrgKeys":                true,
+
+	"InitLedger":       true,
+	"GetCreditIssuer":  true,
+	"GetCreditBalance": true,
+
+	"GetApprovalPolicy":     true,
+	"GetOperation":          true,
+	"ListPendingOperations": true,
+	"CancelOperation":       true,
+}
+
+type Role struct {
+	Name        string   `json:"Name"`
+	Org         string   `json:"Org"`
+	Permissions []string `json:"Permissions"`
+}
+
+func (r *Role) has(perm string) bool {
+	return contains(perm, r.Permissions)
+}
+
+func (s *SmartContract) getRole(ctx contractapi.TransactionContextInterface, org string, name string) (*Role, error) {
+	if name == "admin" {
+		return &Role{Name: name, Org: org, Permissions: allPermissions}, nil
+	}
+
+	rid, err := ctx.GetStub().CreateCompositeKey(roleKeyType, []string{org, name})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	state, err := ctx.GetStub().GetPrivateData(assetUser, rid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %w", err)
+	}
+
+	if state != nil {
+		var r Role
+		err = json.Unmarshal(state, &r)
+		if err != nil {
+			return nil, err
+		}
+		return &r, nil
+	}
+
+	perms, ok := defaultRoles[name]
+	if !ok {
+		return nil, fmt.Errorf("the role %s does not exist", name)
+	}
+
+	return &Role{Name: name, Org: org, Permissions: perms}, nil
+}
+
+// hasPermission reports whether the user's role in org grants perm.
+func (s *SmartContract) hasPermission(ctx contractapi.TransactionContextInterface, org string, usr *User, perm string) bool {
+	role, err := s.getRole(ctx, org, usr.Role)
+	if err != nil {
+		return false
+	}
+
+	return role.has(perm)
+}
+
+// beforeTransaction is the contract-wide hook that enforces txPermissions and
+// refuses transactions that are not listed.
+func (s *SmartContract) beforeTransaction(ctx contractapi.TransactionContextInterface) error {
+	fn, _ := ctx.GetStub().GetFunctionAndParameters()
+
+	if li := strings.LastIndex(fn, ":"); li != -1 {
+		fn = fn[li+1:]
+	}
+
+	perm, ok := txPermissions[fn]
+	if !ok {
+		if openTransactions[fn] {
+			return nil
+		}
+		return fmt.Errorf("the transaction %s is not allowed", fn)
+	}
+
+	org, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed getting the client's MSPID: %v", err)
+	}
+
+	usr, err := s.getUserInfo(ctx, org)
+	if err == nil && s.hasPermission(ctx, org, usr, perm) {
+		return nil
+	}
+
+	// resource scoped transactions may also be allowed by the resource's attribute policies
+	if idx, ok := txResourceArg[fn]; ok {
+		_, params := ctx.GetStub().GetFunctionAndParameters()
+		if idx < len(params) && s.policyAllows(ctx, org, params[idx], perm) {
+			return nil
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to get user info: %v", err)
+	}
+
+	return fmt.Errorf("user %s (role %s) is not authorized to %s: missing permission %s", usr.UserName, usr.Role, fn, perm)
+}
+
+func (s *SmartContract) SetRole(ctx contractapi.TransactionContextInterface, name string, permissions []string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	if name == "" || name == "admin" {
+		return fmt.Errorf("the role %q can't be edited", name)
+	}
+
+	for _, p := range permissions {
+		if !contains(p, allPermissions) {
+			return fmt.Errorf("unknown permission %s", p)
+		}
+	}
+
+	rid, err := ctx.GetStub().CreateCompositeKey(roleKeyType, []string{org, name})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	if permissions == nil {
+		permissions = []string{}
+	}
+
+	data, err := json.Marshal(Role{Name: name, Org: org, Permissions: permissions})
+	if err != nil {
+		return err
+	}
+
+	return s.putState(ctx, assetUser, rid, data)
+}
+
+// DeleteRole removes an org's role definition. Deleting an override of a
+// default role restores the default.
+func (s *SmartContract) DeleteRole(ctx contractapi.TransactionContextInterface, name string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	rid, err := ctx.GetStub().CreateCompositeKey(roleKeyType, []string{org, name})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	if _, err := s.readState(ctx, assetUser, rid); err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelPrivateData(assetUser, rid)
+}
+
+func (s *SmartContract) ListRoles(ctx contractapi.TransactionContextInterface) ([]*Role, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make(map[string]*Role)
+	for name, perms := range defaultRoles {
+		roles[name] = &Role{Name: name, Org: org, Permissions: perms}
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(assetUser, roleKeyType, []string{org})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var r Role
+		err = json.Unmarshal(queryResponse.Value, &r)
+		if err != nil {
+			return nil, err
+		}
+
+		if r.Name != "admin" {
+			roles[r.Name] = &r
+		}
+	}
+
+	names := make([]string, 0, len(roles))
+	for name := range roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := make([]*Role, 0, len(names))
+	for _, name := range names {
+		res = append(res, roles[name])
+	}
+
+	return res, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const (
+	marketRent  = "rent"
+	marketSell  = "sell"
+	marketFixed = "fixed"
+)
+
+// SellOnMarket offers a resource for sale. Ending the element hands the
+// resource over to the winner for good.
+func (s *SmartContract) SellOnMarket(ctx contractapi.TransactionContextInterface, asset_id string, price int) (string, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return s.putResOnMarket(ctx, org, asset_id, ResMarket{Price: price, MarketType: marketSell})
+}
+
+// endSale makes the winner of a sale the owner and user of the resource and
+// removes everything the seller kept about it: credentials, access logs,
+// grants, pools, policies and the seller's inventory id.
+func (s *SmartContract) endSale(ctx contractapi.TransactionContextInterface, res *ResMarket, asset *ComputeRes) error {
+	seller := asset.OwnerOrg
+
+	err := s.handOverCredentials(ctx, asset, "ssh", res.Winner, asset.Id)
+	if err != nil {
+		return err
+	}
+
+	err = s.delCredentials(ctx, asset, seller, asset.Id)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.purgeAccessRecords(ctx, seller, asset.Id, 0)
+	if err != nil {
+		return err
+	}
+
+	err = s.revokeOrgAccess(ctx, seller, asset.Id)
+	if err != nil {
+		return err
+	}
+
+	err = s.removeFromPools(ctx, seller, asset.Id)
+	if err != nil {
+		return err
+	}
+
+	err = s.transition(ctx, asset, stateAvailable, "sold to "+res.Winner)
+	if err != nil {
+		return err
+	}
+
+	asset.OwnerOrg = res.Winner
+	asset.UserOrg = res.Winner
+	asset.UserOrgDueDate = 0
+	asset.User = ""
+	asset.ExternalId = ""
+	asset.AccessLogs = nil
+	asset.AccessPolicies = nil
+	asset.Maintenance = nil
+	asset.Rental = nil
+
+	return s.putComputeRes(ctx, asset.Id, asset)
+}
+
+// revokeOrgAccess removes the grants of every user of org on a resource.
+func (s *SmartContract) revokeOrgAccess(ctx contractapi.TransactionContextInterface, org string, id string) error {
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(assetUser, userKeyType, []string{org})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+
+		var u User
+		err = json.Unmarshal(queryResponse.Value, &u)
+		if err != nil {
+			return err
+		}
+
+		if !contains(id, u.ComputeResList) {
+			continue
+		}
+
+		list := []string{}
+		for _, v := range u.ComputeResList {
+			if v != id {
+				list = append(list, v)
+			}
+		}
+		u.ComputeResList = list
+		delete(u.GrantExpiry, id)
+
+		err = s.putUser(ctx, queryResponse.Key, &u)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeFromPools drops a resource from every pool of org.
+func (s *SmartContract) removeFromPools(ctx contractapi.TransactionContextInterface, org string, id string) error {
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(assetComputeRes, poolKeyType, []string{org})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+
+		var p Pool
+		err = json.Unmarshal(queryResponse.Value, &p)
+		if err != nil {
+			return err
+		}
+
+		if !contains(id, p.Resources) {
+			continue
+		}
+
+		members := []string{}
+		for _, v := range p.Resources {
+			if v != id {
+				members = append(members, v)
+			}
+		}
+		p.Resources = members
+
+		data, err := json.Marshal(p)
+		if err != nil {
+			return fmt.Errorf("failed to marshal pool %s: %v", p.Name, err)
+		}
+
+		err = s.putState(ctx, assetComputeRes, queryResponse.Key, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
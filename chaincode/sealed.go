@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// BidCommitment is the salted hash of a sealed bid, see bidHash.
+type BidCommitment struct {
+	Hash string `json:"hash"`
+	Date int    `json:"date"`
+
+	// Held is the deposit in escrow until the bid is revealed, a bid left
+	// unrevealed past the reveal time forfeits it to the owner
+	Held int `json:"held"`
+}
+
+// SealedBid is a bid as the bidder keeps it in its implicit collection until
+// it is revealed.
+type SealedBid struct {
+	Price int    `json:"price"`
+	Salt  string `json:"salt"`
+}
+
+// the salt keeps other orgs from trying every price against a commitment
+const minSaltLength = 16
+
+// bidHash binds a bid to the element and the bidder, so a commitment can't be
+// copied by another org or to another element.
+func bidHash(id string, org string, bid SealedBid) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%s", id, org, bid.Price, bid.Salt)))
+	return hex.EncodeToString(sum[:])
+}
+
+// PutSealedOnMarket lists a whole machine for rent or sale with sealed bids.
+// Bids are committed until closeTime and revealed until revealTime, both in
+// microseconds since the epoch.
+func (s *SmartContract) PutSealedOnMarket(ctx contractapi.TransactionContextInterface, asset_id string, marketType string, duration int, price int, closeTime int, revealTime int) (string, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if marketType != marketRent && marketType != marketSell {
+		return "", fmt.Errorf("unknown market type %s", marketType)
+	}
+
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return "", err
+	}
+
+	if closeTime <= int(_time.AsTime().UnixMicro()) {
+		return "", fmt.Errorf("the close time must be in the future")
+	}
+
+	if revealTime <= closeTime {
+		return "", fmt.Errorf("the reveal time must be after the close time")
+	}
+
+	return s.putResOnMarket(ctx, org, asset_id, ResMarket{
+		Price:       price,
+		Duration:    duration,
+		MarketType:  marketType,
+		Sealed:      true,
+		CloseTime:   closeTime,
+		RevealTime:  revealTime,
+		Commitments: map[string]BidCommitment{},
+	})
+}
+
+func sealedBidKey(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(sealedBidKeyType, []string{id})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	return key, nil
+}
+
+// transientBid reads the bid from the "bid" transient field, nil if there is
+// none.
+func transientBid(ctx contractapi.TransactionContextInterface) (*SealedBid, error) {
+	data, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, err
+	}
+
+	b_r, ok := data["bid"]
+	if !ok {
+		return nil, nil
+	}
+
+	var bid SealedBid
+	err = json.Unmarshal(b_r, &bid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bid: %v", err)
+	}
+
+	return &bid, nil
+}
+
+// CommitBid bids on a sealed element. The bid is read from the "bid"
+// transient field as {"price", "salt"} and kept in the bidder's implicit
+// collection, the element only records its hash. The asking price goes into
+// escrow as a deposit. Committing again before the close time replaces the
+// bid.
+func (s *SmartContract) CommitBid(ctx contractapi.TransactionContextInterface, id string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.getResMarketElement(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if !res.Sealed {
+		return fmt.Errorf("the element %s takes open bids, use MakePrice", id)
+	}
+
+	if res.Status != "open" {
+		return fmt.Errorf("the market status can't be modified %s", res.Status)
+	}
+
+	if res.OwnerOrg == org {
+		return fmt.Errorf("you can't make price on your own trades")
+	}
+
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+	now := int(_time.AsTime().UnixMicro())
+
+	if now >= res.CloseTime {
+		return fmt.Errorf("bidding on %s closed at %s", id, time.UnixMicro(int64(res.CloseTime)).UTC().Format(time.RFC3339))
+	}
+
+	bid, err := transientBid(ctx)
+	if err != nil {
+		return err
+	}
+	if bid == nil {
+		return fmt.Errorf("the bid must be passed in the bid transient field")
+	}
+
+	if len(bid.Salt) < minSaltLength {
+		return fmt.Errorf("the salt must be at least %d characters", minSaltLength)
+	}
+
+	if bid.Price < res.Price {
+		return fmt.Errorf("you can't make price lower than owner's price")
+	}
+
+	data, err := json.Marshal(*bid)
+	if err != nil {
+		return err
+	}
+
+	key, err := sealedBidKey(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutPrivateData(implicitCollection(org), key, data)
+	if err != nil {
+		return err
+	}
+
+	// the bid itself is hidden, so the deposit is the least it can be
+	b := newCreditBatch(ctx)
+	err = b.hold(org, res.Price-res.Commitments[org].Held, id)
+	if err != nil {
+		return err
+	}
+
+	err = b.commit()
+	if err != nil {
+		return err
+	}
+
+	if res.Commitments == nil {
+		res.Commitments = map[string]BidCommitment{}
+	}
+	res.Commitments[org] = BidCommitment{
+		Hash: bidHash(id, org, *bid),
+		Date: now,
+		Held: res.Price,
+	}
+
+	return s.putResMarketElement(ctx, id, res)
+}
+
+// RevealBid opens the org's bid on a sealed element between the close and the
+// reveal time. The bid kept by CommitBid is used unless one is passed in the
+// "bid" transient field. A bid that doesn't match its commitment is refused,
+// a matching one is entered in Buyers.
+func (s *SmartContract) RevealBid(ctx contractapi.TransactionContextInterface, id string) (BuyerInfo, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return BuyerInfo{}, err
+	}
+
+	res, err := s.getResMarketElement(ctx, id)
+	if err != nil {
+		return BuyerInfo{}, err
+	}
+
+	if !res.Sealed {
+		return BuyerInfo{}, fmt.Errorf("the element %s takes open bids", id)
+	}
+
+	if res.Status != "open" {
+		return BuyerInfo{}, fmt.Errorf("the market status can't be modified %s", res.Status)
+	}
+
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return BuyerInfo{}, err
+	}
+	now := int(_time.AsTime().UnixMicro())
+
+	if now < res.CloseTime {
+		return BuyerInfo{}, fmt.Errorf("bids on %s can be revealed from %s", id, time.UnixMicro(int64(res.CloseTime)).UTC().Format(time.RFC3339))
+	}
+
+	if now >= res.RevealTime {
+		return BuyerInfo{}, fmt.Errorf("bids on %s had to be revealed by %s", id, time.UnixMicro(int64(res.RevealTime)).UTC().Format(time.RFC3339))
+	}
+
+	commitment, ok := res.Commitments[org]
+	if !ok {
+		return BuyerInfo{}, fmt.Errorf("%s has no bid on %s", org, id)
+	}
+
+	if _, ok := res.Buyers[org]; ok {
+		return BuyerInfo{}, fmt.Errorf("the bid of %s on %s is already revealed", org, id)
+	}
+
+	key, err := sealedBidKey(ctx, id)
+	if err != nil {
+		return BuyerInfo{}, err
+	}
+
+	bid, err := transientBid(ctx)
+	if err != nil {
+		return BuyerInfo{}, err
+	}
+
+	if bid == nil {
+		data, err := ctx.GetStub().GetPrivateData(implicitCollection(org), key)
+		if err != nil {
+			return BuyerInfo{}, fmt.Errorf("failed to read the bid: %w", err)
+		}
+		if data == nil {
+			return BuyerInfo{}, fmt.Errorf("no stored bid on %s, pass it in the bid transient field", id)
+		}
+
+		bid = &SealedBid{}
+		err = json.Unmarshal(data, bid)
+		if err != nil {
+			return BuyerInfo{}, err
+		}
+	}
+
+	if bidHash(id, org, *bid) != commitment.Hash {
+		return BuyerInfo{}, fmt.Errorf("the bid doesn't match the commitment of %s", org)
+	}
+
+	err = ctx.GetStub().DelPrivateData(implicitCollection(org), key)
+	if err != nil {
+		return BuyerInfo{}, err
+	}
+
+	// the price is only known now, so the rest of it goes into escrow
+	// and the deposit moves to the bid
+	b := newCreditBatch(ctx)
+	err = b.hold(org, bid.Price-commitment.Held, id)
+	if err != nil {
+		return BuyerInfo{}, err
+	}
+
+	err = b.commit()
+	if err != nil {
+		return BuyerInfo{}, err
+	}
+
+	// bids rank by when they were committed, not revealed
+	buyer := BuyerInfo{
+		Org:   org,
+		Price: bid.Price,
+		Date:  commitment.Date,
+		Held:  bid.Price,
+	}
+
+	if res.Buyers == nil {
+		res.Buyers = map[string]BuyerInfo{}
+	}
+	res.Buyers[org] = buyer
+
+	commitment.Held = 0
+	res.Commitments[org] = commitment
+
+	return buyer, s.putResMarketElement(ctx, id, res)
+}
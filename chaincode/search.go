@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// ResourceFilter selects resources in SearchComputeRes. Zero values match
+// everything.
+type ResourceFilter struct {
+	Arch      string `json:"Arch"`
+	Os        string `json:"Os"`
+	MinCores  int    `json:"MinCores"`
+	MinMemory int    `json:"MinMemory"`
+	GpuModel  string `json:"GpuModel"`
+	MinGpus   int    `json:"MinGpus"`
+	State     string `json:"State"`
+	OwnerOrg  string `json:"OwnerOrg"`
+	Selector  string `json:"Selector"`
+}
+
+// ResourcePage is one page of SearchComputeRes. Total counts every match when
+// the results are sorted in memory, pages read in ascending id order stop at
+// a full page and leave it at -1.
+type ResourcePage struct {
+	Resources []*ComputeRes `json:"Resources"`
+	Bookmark  string        `json:"Bookmark"`
+	Total     int           `json:"Total"`
+}
+
+func (f *ResourceFilter) matches(c *ComputeRes, sel labelSelector) bool {
+	if !sel.matches(c.Labels) {
+		return false
+	}
+
+	if f.Arch != "" && !strings.EqualFold(f.Arch, c.Spec.Arch) {
+		return false
+	}
+
+	if f.Os != "" && !strings.EqualFold(f.Os, c.Spec.Os) {
+		return false
+	}
+
+	if c.Spec.Cores < f.MinCores || c.Spec.Memory < f.MinMemory {
+		return false
+	}
+
+	if f.GpuModel != "" || f.MinGpus > 0 {
+		gpus := 0
+		for _, g := range c.Spec.Gpus {
+			if f.GpuModel == "" || strings.Contains(strings.ToLower(g.Model), strings.ToLower(f.GpuModel)) {
+				gpus++
+			}
+		}
+		if gpus == 0 || gpus < f.MinGpus {
+			return false
+		}
+	}
+
+	if f.State != "" && f.State != c.State {
+		return false
+	}
+
+	if f.OwnerOrg != "" && f.OwnerOrg != c.OwnerOrg {
+		return false
+	}
+
+	return true
+}
+
+// resourceSortKeys are the orders SearchComputeRes supports. Keys compare as
+// strings, so numbers are zero padded.
+var resourceSortKeys = map[string]func(c *ComputeRes) string{
+	"id":       func(c *ComputeRes) string { return "" },
+	"name":     func(c *ComputeRes) string { return c.Name },
+	"state":    func(c *ComputeRes) string { return c.State },
+	"cores":    func(c *ComputeRes) string { return fmt.Sprintf("%020d", c.Spec.Cores) },
+	"memory":   func(c *ComputeRes) string { return fmt.Sprintf("%020d", c.Spec.Memory) },
+	"gpus":     func(c *ComputeRes) string { return fmt.Sprintf("%020d", len(c.Spec.Gpus)) },
+	"lastseen": func(c *ComputeRes) string { return fmt.Sprintf("%020d", c.LastSeen) },
+}
+
+// SearchComputeRes returns one page of the resources visible to the org that
+// match filter, ordered by sortBy. Pass the returned bookmark to get the next
+// page, an empty bookmark means there are no more results.
+func (s *SmartContract) SearchComputeRes(ctx contractapi.TransactionContextInterface, filter ResourceFilter, sortBy string, descending bool, pageSize int, bookmark string) (ResourcePage, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return ResourcePage{}, err
+	}
+
+	if sortBy == "" {
+		sortBy = "id"
+	}
+
+	sortKey, ok := resourceSortKeys[sortBy]
+	if !ok {
+		return ResourcePage{}, fmt.Errorf("can't sort by %s", sortBy)
+	}
+
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	sel, err := parseSelector(filter.Selector)
+	if err != nil {
+		return ResourcePage{}, err
+	}
+
+	var after string
+	if bookmark != "" {
+		b, err := base64.RawURLEncoding.DecodeString(bookmark)
+		if err != nil {
+			return ResourcePage{}, fmt.Errorf("invalid bookmark")
+		}
+		after = string(b)
+	}
+
+	// resources come in id order, so those pages start at the bookmark and
+	// stop once they are full
+	bounded := sortBy == "id" && !descending
+
+	from := ""
+	if bounded {
+		from = strings.TrimPrefix(after, "\x00")
+	}
+
+	type entry struct {
+		key   string
+		asset *ComputeRes
+	}
+
+	var entries []entry
+	err = s.scanComputeRes(ctx, filter.OwnerOrg, from, func(asset *ComputeRes) (bool, error) {
+		if !asset.visibleTo(org) || !filter.matches(asset, sel) {
+			return true, nil
+		}
+
+		if sortBy == "lastseen" {
+			asset.LastSeen, err = s.lastSeen(ctx, asset)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		entries = append(entries, entry{key: sortKey(asset) + "\x00" + asset.Id, asset: asset})
+
+		return !bounded || len(entries) < pageSize, nil
+	})
+	if err != nil {
+		return ResourcePage{}, err
+	}
+
+	page := ResourcePage{Resources: []*ComputeRes{}, Total: len(entries)}
+
+	if bounded {
+		page.Total = -1
+		after = ""
+	} else {
+		sort.Slice(entries, func(i, j int) bool {
+			if descending {
+				return entries[i].key > entries[j].key
+			}
+			return entries[i].key < entries[j].key
+		})
+	}
+
+	for _, e := range entries {
+		if after != "" && (!descending && e.key <= after || descending && e.key >= after) {
+			continue
+		}
+
+		if len(page.Resources) == pageSize {
+			page.Bookmark = base64.RawURLEncoding.EncodeToString([]byte(after))
+			break
+		}
+
+		if sortBy != "lastseen" {
+			e.asset.LastSeen, err = s.lastSeen(ctx, e.asset)
+			if err != nil {
+				return ResourcePage{}, err
+			}
+		}
+
+		e.asset.redactListing(org)
+		page.Resources = append(page.Resources, e.asset)
+		after = e.key
+	}
+
+	// a full page read in id order may have more after it
+	if bounded && len(page.Resources) == pageSize {
+		page.Bookmark = base64.RawURLEncoding.EncodeToString([]byte(after))
+	}
+
+	return page, nil
+}
+
+// scanComputeRes calls fn with the resources after the id from in id order
+// until fn returns false. With an owner only the owner's resources are read,
+// through the owner index.
+func (s *SmartContract) scanComputeRes(ctx contractapi.TransactionContextInterface, owner string, from string, fn func(c *ComputeRes) (bool, error)) error {
+	if owner != "" {
+		ids, err := indexedIds(ctx, assetComputeRes, ownerIndex, owner)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if id <= from {
+				continue
+			}
+
+			asset, err := s.readComputeRes(ctx, id)
+			if err != nil {
+				return err
+			}
+
+			// the index may be stale, see RebuildIndexes
+			if asset.OwnerOrg != owner {
+				continue
+			}
+
+			more, err := fn(asset)
+			if err != nil || !more {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	// resources have simple keys, the smallest one after from is from+"\x00"
+	start := ""
+	if from != "" {
+		start = from + "\x00"
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByRange(assetComputeRes, start, "")
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+
+		var asset ComputeRes
+		err = json.Unmarshal(queryResponse.Value, &asset)
+		if err != nil {
+			return err
+		}
+
+		asset.normalizeState()
+
+		more, err := fn(&asset)
+		if err != nil || !more {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type GpuSpec struct {
+	Vendor string `json:"Vendor"`
+	Model  string `json:"Model"`
+	Memory int    `json:"Memory"`
+}
+
+type DiskSpec struct {
+	Name string `json:"Name"`
+	Type string `json:"Type"`
+	Size int    `json:"Size"`
+}
+
+// HardwareSpec is the normalized hardware description of a resource. Memory
+// and sizes are in bytes, NicSpeed is in Mbit/s.
+type HardwareSpec struct {
+	Os   string `json:"Os"`
+	Arch string `json:"Arch"`
+
+	CpuModel string `json:"CpuModel"`
+	Cores    int    `json:"Cores"`
+	Threads  int    `json:"Threads"`
+	Sockets  int    `json:"Sockets"`
+
+	Memory int `json:"Memory"`
+
+	Gpus  []GpuSpec  `json:"Gpus,omitempty" metadata:",optional"`
+	Disks []DiskSpec `json:"Disks,omitempty" metadata:",optional"`
+
+	NicSpeed int `json:"NicSpeed"`
+
+	Ip       string `json:"Ip"`
+	Hostname string `json:"Hostname"`
+}
+
+var diskTypes = []string{"hdd", "ssd", "nvme"}
+
+func (h *HardwareSpec) validate() error {
+	if h.Arch == "" {
+		return fmt.Errorf("the spec has no arch")
+	}
+
+	if h.Sockets < 1 || h.Cores < h.Sockets {
+		return fmt.Errorf("the spec needs at least one socket and one core per socket")
+	}
+
+	if h.Threads < h.Cores {
+		return fmt.Errorf("the spec has fewer threads than cores")
+	}
+
+	if h.Memory <= 0 {
+		return fmt.Errorf("the spec has no memory")
+	}
+
+	if h.NicSpeed < 0 {
+		return fmt.Errorf("invalid nic speed %d", h.NicSpeed)
+	}
+
+	for _, g := range h.Gpus {
+		if g.Model == "" || g.Memory < 0 {
+			return fmt.Errorf("invalid gpu %+v", g)
+		}
+	}
+
+	for _, d := range h.Disks {
+		if d.Size <= 0 || !contains(d.Type, diskTypes) {
+			return fmt.Errorf("invalid disk %+v", d)
+		}
+	}
+
+	return nil
+}
+
+var memoryUnits = map[string]float64{
+	"":   1,
+	"B":  1,
+	"K":  1 << 10,
+	"M":  1 << 20,
+	"G":  1 << 30,
+	"T":  1 << 40,
+	"KI": 1 << 10,
+	"MI": 1 << 20,
+	"GI": 1 << 30,
+	"TI": 1 << 40,
+	"KB": 1e3,
+	"MB": 1e6,
+	"GB": 1e9,
+	"TB": 1e12,
+}
+
+var memoryPattern = regexp.MustCompile(`^([0-9]+(?:[.,][0-9]+)?)\s*([A-Za-z]*)$`)
+
+// parseMemory reads sizes as printed by `free -h`, e.g. "62Gi" or "7.6G".
+func parseMemory(s string) (int, error) {
+	m := memoryPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("invalid memory size %q", s)
+	}
+
+	v, err := strconv.ParseFloat(strings.Replace(m[1], ",", ".", 1), 64)
+	if err != nil {
+		return 0, err
+	}
+
+	unit, ok := memoryUnits[strings.ToUpper(m[2])]
+	if !ok {
+		return 0, fmt.Errorf("invalid memory unit %q", m[2])
+	}
+
+	return int(v * unit), nil
+}
+
+var lspciRevision = regexp.MustCompile(`\(rev [0-9a-fA-F]+\)`)
+
+var lspciModel = regexp.MustCompile(`\[([^\]]+)\]`)
+
+// parseGpus splits the lspci fragments setup.sh joins into one line.
+func parseGpus(s string) []GpuSpec {
+	gpus := []GpuSpec{}
+
+	for _, dev := range lspciRevision.Split(s, -1) {
+		dev = strings.TrimSpace(dev)
+		if dev == "" || strings.Contains(strings.ToLower(dev), "audio") {
+			continue
+		}
+
+		vendor := strings.Fields(dev)[0]
+		model := strings.TrimSpace(strings.TrimPrefix(dev, vendor))
+		model = strings.TrimSpace(strings.TrimPrefix(model, "Corporation"))
+
+		if mm := lspciModel.FindStringSubmatch(dev); mm != nil {
+			model = mm[1]
+		}
+
+		gpus = append(gpus, GpuSpec{Vendor: vendor, Model: model})
+	}
+
+	return gpus
+}
+
+// specFromLegacy converts the string payload posted by setup.sh.
+func specFromLegacy(u ComputeResUpdate) (HardwareSpec, error) {
+	h := HardwareSpec{
+		Os:       u.Os,
+		Arch:     u.Arch,
+		CpuModel: u.CpuSKU,
+		Ip:       u.Ip,
+		Hostname: u.Hostname,
+	}
+
+	sockets, err := strconv.Atoi(strings.TrimSpace(u.CpuSockets))
+	if err != nil {
+		return h, fmt.Errorf("invalid cpusockets %q", u.CpuSockets)
+	}
+
+	// lscpu reports cores per socket
+	cores, err := strconv.Atoi(strings.TrimSpace(u.CpuCores))
+	if err != nil {
+		return h, fmt.Errorf("invalid cpucores %q", u.CpuCores)
+	}
+
+	h.Sockets = sockets
+	h.Cores = cores * sockets
+	h.Threads = h.Cores
+
+	h.Memory, err = parseMemory(u.Ram)
+	if err != nil {
+		return h, err
+	}
+
+	h.Gpus = parseGpus(u.GpuSKU)
+
+	if n, err := strconv.Atoi(strings.TrimSpace(u.GpuNum)); err == nil && len(h.Gpus) == 1 {
+		for len(h.Gpus) < n {
+			h.Gpus = append(h.Gpus, h.Gpus[0])
+		}
+	}
+
+	return h, h.validate()
+}
@@ -13,7 +13,22 @@ type User struct {
 	Role string `json:"Role"`
 	Org  string `json:"Org"`
 
-	ComputeResList []string `json:"ComputeResList"`
+	ComputeResList []string       `json:"ComputeResList"`
+	GrantExpiry    map[string]int `json:"GrantExpiry,omitempty" metadata:",optional"`
+
+	Disabled bool `json:"Disabled"`
+}
+
+// hasGrant reports whether the user holds an unexpired grant on the resource.
+// An expiry of 0 means the grant never expires.
+func (u *User) hasGrant(id string, now int) bool {
+	if !contains(id, u.ComputeResList) {
+		return false
+	}
+
+	expiry := u.GrantExpiry[id]
+
+	return expiry == 0 || now < expiry
 }
 
 func (s *SmartContract) CreateRootUser(ctx contractapi.TransactionContextInterface) error {
@@ -44,6 +59,9 @@ func (s *SmartContract) CreateRootUser(ctx contractapi.TransactionContextInterfa
 	root := User{
 		UserName: thisuser,
 		Role:     "admin",
+		Org:      org,
+
+		ComputeResList: []string{},
 	}
 
 	assetJSON, err := json.Marshal(root)
@@ -71,9 +89,19 @@ func (s *SmartContract) getUserInfo(ctx contractapi.TransactionContextInterface,
 		return nil, fmt.Errorf("failed to create composite key: %v", err)
 	}
 
+	attrRole, hasAttrRole, err := ctx.GetClientIdentity().GetAttributeValue(attrRoleName)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate attributes: %v", err)
+	}
+
 	state, err := s.readState(ctx, assetUser, uid)
 
 	if err != nil {
+		// a certificate issued with an obc.role attribute acts as a user of that role
+		if hasAttrRole {
+			return &User{UserName: user, Role: attrRole, Org: org, ComputeResList: []string{}}, nil
+		}
 		return nil, fmt.Errorf("failed to read state: %v", err)
 	}
 
@@ -84,13 +112,240 @@ func (s *SmartContract) getUserInfo(ctx contractapi.TransactionContextInterface,
 		return nil, fmt.Errorf("failed to unmarshal state: %v", err)
 	}
 
+	if u.Disabled {
+		return nil, fmt.Errorf("user %s is disabled", u.UserName)
+	}
+
+	if hasAttrRole {
+		u.Role = attrRole
+	}
+
 	return &u, nil
 
 }
 
+func (s *SmartContract) readUser(ctx contractapi.TransactionContextInterface, org string, user string) (string, *User, error) {
+	uid, err := ctx.GetStub().CreateCompositeKey(userKeyType, []string{org, user})
+
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	state, err := s.readState(ctx, assetUser, uid)
+
+	if err != nil {
+		return uid, nil, err
+	}
+
+	var u User
+	err = json.Unmarshal(state, &u)
+
+	if err != nil {
+		return uid, nil, fmt.Errorf("failed to unmarshal state: %v", err)
+	}
+
+	return uid, &u, nil
+}
+
+func (s *SmartContract) putUser(ctx contractapi.TransactionContextInterface, uid string, u *User) error {
+	data, err := json.Marshal(*u)
+	if err != nil {
+		return err
+	}
+
+	return s.putState(ctx, assetUser, uid, data)
+}
+
+func (s *SmartContract) isRootUser(ctx contractapi.TransactionContextInterface, org string, user string) bool {
+	rootuser, _ := ctx.GetStub().CreateCompositeKey(userKeyType, []string{org, _rootuser})
+
+	state, err := s.readState(ctx, assetUser, rootuser)
+	if err != nil {
+		return false
+	}
+
+	var root User
+	if json.Unmarshal(state, &root) != nil {
+		return false
+	}
+
+	return root.UserName == user
+}
+
+// requireAssignable refuses roles that grant a permission the caller
+// doesn't hold, so managing users can't raise anyone above the manager.
+func (s *SmartContract) requireAssignable(ctx contractapi.TransactionContextInterface, org string, role string) (*User, error) {
+	r, err := s.getRole(ctx, org, role)
+	if err != nil {
+		return nil, err
+	}
+
+	caller, err := s.getUserInfo(ctx, org)
+	if err != nil {
+		return nil, err
+	}
+
+	own, err := s.getRole(ctx, org, caller.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range r.Permissions {
+		if !own.has(p) {
+			return nil, fmt.Errorf("the role %s grants %s, which %s doesn't hold", role, p, caller.UserName)
+		}
+	}
+
+	return caller, nil
+}
+
+func (s *SmartContract) AddUser(ctx contractapi.TransactionContextInterface, user string, role string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	if user == "" || user == _rootuser {
+		return fmt.Errorf("invalid user name %q", user)
+	}
+
+	if _, err := s.requireAssignable(ctx, org, role); err != nil {
+		return err
+	}
+
+	uid, existing, _ := s.readUser(ctx, org, user)
+
+	if existing != nil {
+		return fmt.Errorf("the user %s already exists", user)
+	}
+
+	return s.putUser(ctx, uid, &User{
+		UserName: user,
+		Role:     role,
+		Org:      org,
+
+		ComputeResList: []string{},
+	})
+}
+
+func (s *SmartContract) RemoveUser(ctx contractapi.TransactionContextInterface, user string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	if s.isRootUser(ctx, org, user) {
+		return fmt.Errorf("the root user can't be removed")
+	}
+
+	uid, _, err := s.readUser(ctx, org, user)
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelPrivateData(assetUser, uid)
+}
+
+func (s *SmartContract) SetUserRole(ctx contractapi.TransactionContextInterface, user string, role string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	caller, err := s.requireAssignable(ctx, org, role)
+	if err != nil {
+		return err
+	}
+
+	if caller.UserName == user {
+		return fmt.Errorf("users can't change their own role")
+	}
+
+	if s.isRootUser(ctx, org, user) {
+		return fmt.Errorf("the root user's role can't be changed")
+	}
+
+	uid, u, err := s.readUser(ctx, org, user)
+
+	if err != nil {
+		return err
+	}
+
+	u.Role = role
+
+	return s.putUser(ctx, uid, u)
+}
+
+func (s *SmartContract) SetUserDisabled(ctx contractapi.TransactionContextInterface, user string, disabled bool) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	if s.isRootUser(ctx, org, user) {
+		return fmt.Errorf("the root user can't be disabled")
+	}
+
+	uid, u, err := s.readUser(ctx, org, user)
+
+	if err != nil {
+		return err
+	}
+
+	u.Disabled = disabled
+
+	return s.putUser(ctx, uid, u)
+}
+
+func (s *SmartContract) ListUsers(ctx contractapi.TransactionContextInterface) ([]*User, error) {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(assetUser, userKeyType, []string{org})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	users := []*User{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, keys, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(keys) != 2 || keys[1] == _rootuser {
+			continue
+		}
+
+		var u User
+		err = json.Unmarshal(queryResponse.Value, &u)
+		if err != nil {
+			return nil, err
+		}
+
+		if u.ComputeResList == nil {
+			u.ComputeResList = []string{}
+		}
+
+		users = append(users, &u)
+	}
+
+	return users, nil
+}
+
 type UserInfoStruct struct {
 	User string `json:"user"`
 	Org  string `json:"org"`
+	Role string `json:"role"`
 }
 
 func (s *SmartContract) GetUserInfo(ctx contractapi.TransactionContextInterface) (UserInfoStruct, error) {
@@ -98,5 +353,87 @@ func (s *SmartContract) GetUserInfo(ctx contractapi.TransactionContextInterface)
 
 	usr, _ := s.GetSubmittingClientIdentity(ctx)
 
-	return UserInfoStruct{User: usr, Org: org}, nil
+	role := ""
+	if u, err := s.getUserInfo(ctx, org); err == nil {
+		role = u.Role
+	}
+
+	return UserInfoStruct{User: usr, Org: org, Role: role}, nil
+}
+
+func (s *SmartContract) GrantAccess(ctx contractapi.TransactionContextInterface, user string, id string, expiry int) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.viewComputeRes(ctx, id); err != nil {
+		return err
+	}
+
+	return s.grantAccess(ctx, org, user, []string{id}, expiry)
+}
+
+// grantAccess grants user access to all ids with a single write of the user.
+func (s *SmartContract) grantAccess(ctx contractapi.TransactionContextInterface, org string, user string, ids []string, expiry int) error {
+	_time, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+
+	if expiry != 0 && expiry <= int(_time.AsTime().UnixMicro()) {
+		return fmt.Errorf("the grant expiry is in the past")
+	}
+
+	uid, u, err := s.readUser(ctx, org, user)
+
+	if err != nil {
+		return err
+	}
+
+	if u.GrantExpiry == nil {
+		u.GrantExpiry = make(map[string]int)
+	}
+
+	for _, id := range ids {
+		if !contains(id, u.ComputeResList) {
+			u.ComputeResList = append(u.ComputeResList, id)
+		}
+
+		if expiry == 0 {
+			delete(u.GrantExpiry, id)
+		} else {
+			u.GrantExpiry[id] = expiry
+		}
+	}
+
+	return s.putUser(ctx, uid, u)
+}
+
+func (s *SmartContract) RevokeAccess(ctx contractapi.TransactionContextInterface, user string, id string) error {
+	org, err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+
+	uid, u, err := s.readUser(ctx, org, user)
+
+	if err != nil {
+		return err
+	}
+
+	if !contains(id, u.ComputeResList) {
+		return fmt.Errorf("user %s has no access to %s", user, id)
+	}
+
+	list := []string{}
+	for _, v := range u.ComputeResList {
+		if v != id {
+			list = append(list, v)
+		}
+	}
+	u.ComputeResList = list
+	delete(u.GrantExpiry, id)
+
+	return s.putUser(ctx, uid, u)
 }
@@ -3,10 +3,14 @@ package main
 import (
 	"encoding/base64"
 	"fmt"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/hyperledger/fabric-chaincode-go/v2/pkg/statebased"
 	"github.com/hyperledger/fabric-chaincode-go/v2/shim"
 	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
 )
 
 func (s *SmartContract) readState(ctx contractapi.TransactionContextInterface, _type string, id string) ([]byte, error) {
@@ -22,9 +26,43 @@ func (s *SmartContract) readState(ctx contractapi.TransactionContextInterface, _
 }
 
 func (s *SmartContract) putState(ctx contractapi.TransactionContextInterface, _type string, id string, data []byte) error {
+	err := s.beforeWrite(ctx, _type, id, data)
+	if err != nil {
+		return err
+	}
+
 	return ctx.GetStub().PutPrivateData(_type, id, data)
 }
 
+func (s *SmartContract) delState(ctx contractapi.TransactionContextInterface, _type string, id string) error {
+	err := s.beforeWrite(ctx, _type, id, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelPrivateData(_type, id)
+}
+
+// beforeWrite logs the change and moves the index entries of a resource or
+// market element about to be replaced by data, nil for a delete.
+func (s *SmartContract) beforeWrite(ctx contractapi.TransactionContextInterface, _type string, id string, data []byte) error {
+	if !tracked(_type, id) {
+		return nil
+	}
+
+	before, err := ctx.GetStub().GetPrivateData(_type, id)
+	if err != nil {
+		return err
+	}
+
+	err = s.recordChange(ctx, _type, id, before, data)
+	if err != nil {
+		return err
+	}
+
+	return s.updateIndexes(ctx, _type, id, before, data)
+}
+
 func (s *SmartContract) GetSubmittingClientIdentity(ctx contractapi.TransactionContextInterface) (string, error) {
 
 	b64ID, err := ctx.GetClientIdentity().GetID()
@@ -77,6 +115,12 @@ func setAssetStateBasedEndorsement(ctx contractapi.TransactionContextInterface,
 	return nil
 }
 
+// implicitCollection returns the name of the org's implicit private data
+// collection, which only that org's peers persist.
+func implicitCollection(org string) string {
+	return "_implicit_org_" + org
+}
+
 func contains(val string, collection []string) bool {
 	for _, v := range collection {
 		if val == v {
@@ -85,3 +129,72 @@ func contains(val string, collection []string) bool {
 	}
 	return false
 }
+
+// rangeKey builds a key laid out like a composite key but without the leading
+// null. It is a simple key, so unlike a composite key a range read with
+// GetPrivateDataByRange can start and stop anywhere under it, e.g. at a
+// bookmark. Records read in pages are kept under such keys.
+func rangeKey(ctx contractapi.TransactionContextInterface, objectType string, attributes ...string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	return key[1:], nil
+}
+
+// timeRange returns the bounds of a range read of the keys under prefix whose
+// next attribute is a zero padded time between from and to, 0 for no upper
+// bound. A bookmarked key moves the start past it.
+func timeRange(prefix string, from int, to int, bookmark string) (string, string, error) {
+	start := prefix + fmt.Sprintf("%020d", from)
+
+	if bookmark != "" {
+		b, err := base64.RawURLEncoding.DecodeString(bookmark)
+		if err != nil || !strings.HasPrefix(string(b), prefix) {
+			return "", "", fmt.Errorf("invalid bookmark")
+		}
+
+		// the smallest key after the bookmarked one
+		if after := string(b) + "\x00"; after > start {
+			start = after
+		}
+	}
+
+	end := prefix + string(utf8.MaxRune)
+	if to != 0 {
+		end = prefix + fmt.Sprintf("%020d", to)
+	}
+
+	return start, end, nil
+}
+
+// isInit reports whether the transaction initializes the chaincode. With
+// --init-required the peers accept exactly one such transaction per chaincode
+// definition, before any other.
+func isInit(ctx contractapi.TransactionContextInterface) (bool, error) {
+	sp, err := ctx.GetStub().GetSignedProposal()
+	if err != nil {
+		return false, err
+	}
+
+	var prop peer.Proposal
+	err = proto.Unmarshal(sp.GetProposalBytes(), &prop)
+	if err != nil {
+		return false, err
+	}
+
+	var payload peer.ChaincodeProposalPayload
+	err = proto.Unmarshal(prop.GetPayload(), &payload)
+	if err != nil {
+		return false, err
+	}
+
+	var spec peer.ChaincodeInvocationSpec
+	err = proto.Unmarshal(payload.GetInput(), &spec)
+	if err != nil {
+		return false, err
+	}
+
+	return spec.GetChaincodeSpec().GetInput().GetIsInit(), nil
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CredentialEnvelope carries SSHAccessDetails sealed for one org. A fresh data
+// key encrypts the payload and is itself wrapped with a key agreed between an
+// ephemeral X25519 key and the recipient org's published credential key.
+type CredentialEnvelope struct {
+	Version int    `json:"v"`
+	Org     string `json:"org"`
+	Epk     string `json:"epk"`
+	Key     string `json:"key"`
+	Nonce   string `json:"nonce"`
+	Data    string `json:"data"`
+}
+
+var credKeyPath = "credential.key"
+
+var credKey *ecdh.PrivateKey
+
+var orgKeys sync.Map
+
+// loadCredentialKey reads the org's credential key, creating it on first start.
+// The private half never leaves this process.
+func loadCredentialKey() {
+	TestEnv("OBC_CREDENTIAL_KEY", &credKeyPath)
+
+	raw, err := os.ReadFile(credKeyPath)
+
+	if errors.Is(err, os.ErrNotExist) {
+		credKey, err = ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			panic(fmt.Errorf("failed to generate credential key: %w", err))
+		}
+
+		err = os.WriteFile(credKeyPath, []byte(base64.StdEncoding.EncodeToString(credKey.Bytes())), 0600)
+		if err != nil {
+			panic(fmt.Errorf("failed to write credential key: %w", err))
+		}
+
+		log.Info().Str("path", credKeyPath).Msg("generated credential key")
+		return
+	}
+
+	if err != nil {
+		panic(fmt.Errorf("failed to read credential key: %w", err))
+	}
+
+	b, err := base64.StdEncoding.DecodeString(string(raw))
+	if err != nil {
+		panic(fmt.Errorf("failed to decode credential key: %w", err))
+	}
+
+	credKey, err = ecdh.X25519().NewPrivateKey(b)
+	if err != nil {
+		panic(fmt.Errorf("invalid credential key: %w", err))
+	}
+}
+
+// publishCredentialKey stores our public key on the ledger so other orgs can
+// seal credentials for us.
+func publishCredentialKey() {
+	Invoke("SetOrgKey", base64.StdEncoding.EncodeToString(credKey.PublicKey().Bytes()))
+}
+
+func orgPublicKey(org string) (*ecdh.PublicKey, error) {
+	if org == mspID {
+		return credKey.PublicKey(), nil
+	}
+
+	if k, ok := orgKeys.Load(org); ok {
+		return k.(*ecdh.PublicKey), nil
+	}
+
+	data, err := Query("GetOrgKey", org)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	k, err := ecdh.X25519().NewPublicKey(b)
+	if err != nil {
+		return nil, err
+	}
+
+	orgKeys.Store(org, k)
+
+	return k, nil
+}
+
+func wrappingKey(shared, epk, pub []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("obc-credential-v1"))
+	h.Write(shared)
+	h.Write(epk)
+	h.Write(pub)
+	return h.Sum(nil)
+}
+
+func gcmSeal(key, nonce, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nil, nonce, plaintext, nil), nil
+}
+
+func gcmOpen(key, nonce, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// sealCredentials encrypts details so that only org's gateway can open them.
+func sealCredentials(org string, details SSHAccessDetails) ([]byte, error) {
+	pub, err := orgPublicKey(org)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credential key of %s: %w", org, err)
+	}
+
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := eph.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	dek := make([]byte, 32)
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(details)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := gcmSeal(dek, nonce, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// the data key is wrapped with a zero nonce, its wrapping key is single use
+	wrapped, err := gcmSeal(wrappingKey(shared, eph.PublicKey().Bytes(), pub.Bytes()), make([]byte, 12), dek)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(CredentialEnvelope{
+		Version: 1,
+		Org:     org,
+		Epk:     base64.StdEncoding.EncodeToString(eph.PublicKey().Bytes()),
+		Key:     base64.StdEncoding.EncodeToString(wrapped),
+		Nonce:   base64.StdEncoding.EncodeToString(nonce),
+		Data:    base64.StdEncoding.EncodeToString(data),
+	})
+}
+
+// openCredentials decrypts an envelope sealed for our org.
+func openCredentials(data []byte) (SSHAccessDetails, error) {
+	var env CredentialEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return SSHAccessDetails{}, err
+	}
+
+	if env.Version != 1 || env.Org != mspID {
+		return SSHAccessDetails{}, fmt.Errorf("credentials are not sealed for %s", mspID)
+	}
+
+	var fields [4][]byte
+	for i, f := range []string{env.Epk, env.Key, env.Nonce, env.Data} {
+		b, err := base64.StdEncoding.DecodeString(f)
+		if err != nil {
+			return SSHAccessDetails{}, err
+		}
+		fields[i] = b
+	}
+
+	epk, err := ecdh.X25519().NewPublicKey(fields[0])
+	if err != nil {
+		return SSHAccessDetails{}, err
+	}
+
+	shared, err := credKey.ECDH(epk)
+	if err != nil {
+		return SSHAccessDetails{}, err
+	}
+
+	dek, err := gcmOpen(wrappingKey(shared, fields[0], credKey.PublicKey().Bytes()), make([]byte, 12), fields[1])
+	if err != nil {
+		return SSHAccessDetails{}, fmt.Errorf("failed to unwrap credential key: %w", err)
+	}
+
+	payload, err := gcmOpen(dek, fields[2], fields[3])
+	if err != nil {
+		return SSHAccessDetails{}, fmt.Errorf("failed to decrypt credentials: %w", err)
+	}
+
+	var details SSHAccessDetails
+	err = json.Unmarshal(payload, &details)
+
+	return details, err
+}
+
+// resealCredentials reads our sealed credentials for a resource and seals
+// them again for org, e.g. to hand them over to a renter.
+func resealCredentials(id string, org string) ([]byte, error) {
+	data, err := Query("GetCredentials", id)
+	if err != nil {
+		return nil, err
+	}
+
+	details, err := openCredentials(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return sealCredentials(org, details)
+}
+
+// migrateCredentials seals any credentials still kept in clear on the ledger.
+func migrateCredentials() (int, error) {
+	data, err := Query("ListLegacyCredentials")
+	if err != nil {
+		return 0, err
+	}
+
+	var legacy map[string]SSHAccessDetails
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return 0, err
+	}
+
+	if len(legacy) == 0 {
+		return 0, nil
+	}
+
+	sealed := make(map[string]json.RawMessage, len(legacy))
+	for id, details := range legacy {
+		env, err := sealCredentials(mspID, details)
+		if err != nil {
+			return 0, err
+		}
+		sealed[id] = env
+	}
+
+	_d, err := json.Marshal(sealed)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := InvokeTransistent("MigrateCredentials", map[string][]byte{"credentials": _d}); err != nil {
+		return 0, err
+	}
+
+	return len(legacy), nil
+}
+
+// sealForWinner prepares the transient for ending or settling a market
+// element: the given details, or else our own stored credentials, sealed for
+// the winner. The winner of an element to settle is the one its policy picks.
+// Our credentials give the whole machine, so a slice needs details of its own
+// and they go in the "lease" field instead of "ssh". A bidder settling an
+// element of another org passes nothing, the owner ends it later.
+func sealForWinner(marketId string, details map[string]string) (map[string][]byte, error) {
+	data, err := Query("GetMarketElement", marketId)
+	if err != nil {
+		return nil, err
+	}
+
+	var element struct {
+		Winner   string `json:"winner"`
+		OwnerOrg string `json:"ownerOrg"`
+		Res      struct {
+			Id string `json:"Id"`
+		} `json:"resource"`
+		Units map[string]int `json:"units"`
+	}
+	if err := json.Unmarshal(data, &element); err != nil {
+		return nil, err
+	}
+
+	if element.OwnerOrg != mspID {
+		return map[string][]byte{}, nil
+	}
+
+	if element.Winner == "" {
+		data, err := Query("PickWinner", marketId)
+		if err != nil {
+			return nil, err
+		}
+
+		var winner struct {
+			Org string `json:"org"`
+		}
+		if err := json.Unmarshal(data, &winner); err != nil {
+			return nil, err
+		}
+		element.Winner = winner.Org
+	}
+
+	slice := false
+	for _, n := range element.Units {
+		slice = slice || n != 0
+	}
+
+	if details == nil {
+		if slice {
+			return nil, fmt.Errorf("the slice %s needs credentials of its own, post them to end it", marketId)
+		}
+
+		_d, err := resealCredentials(element.Res.Id, element.Winner)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string][]byte{"ssh": _d}, nil
+	}
+
+	_d, err := sealCredentials(element.Winner, SSHAccessDetails{
+		User: details["user"],
+		Pass: details["pass"],
+		Addr: details["addr"],
+		Idn:  details["idn"],
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if slice {
+		return map[string][]byte{"lease": _d}, nil
+	}
+
+	return map[string][]byte{"ssh": _d}, nil
+}
+
+// offerCredentials prepares the "ssh" transient of a fixed price listing: our
+// stored credentials of a resource sealed for every other org with a
+// published key.
+func offerCredentials(id string) ([]byte, error) {
+	data, err := Query("ListOrgKeys")
+	if err != nil {
+		return nil, err
+	}
+
+	var keys map[string]string
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+
+	offers := map[string]json.RawMessage{}
+	for org := range keys {
+		if org == mspID {
+			continue
+		}
+
+		_d, err := resealCredentials(id, org)
+		if err != nil {
+			return nil, err
+		}
+		offers[org] = _d
+	}
+
+	return json.Marshal(offers)
+}
+
+// approvalTransient returns the transient data an approval may need when it
+// ends up executing the proposed operation, with details as in sealForWinner.
+func approvalTransient(id string, details map[string]string) (map[string][]byte, error) {
+	data, err := Query("GetOperation", id)
+	if err != nil {
+		return nil, err
+	}
+
+	var p struct {
+		Function string   `json:"Function"`
+		Args     []string `json:"Args"`
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+
+	if p.Function != "EndMarketElement" && p.Function != "SettleMarketElement" || len(p.Args) != 1 {
+		return map[string][]byte{}, nil
+	}
+
+	return sealForWinner(p.Args[0], details)
+}
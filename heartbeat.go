@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type Heartbeat struct {
+	Id       string `json:"Id"`
+	LastSeen int    `json:"LastSeen"`
+}
+
+var (
+	heartbeatFlush   = "1m"
+	heartbeatTimeout = "5m"
+)
+
+var heartbeatKeyPath = "heartbeat.key"
+
+var heartbeatKey []byte
+
+// loadHeartbeatKey reads the key heartbeat tokens are derived from, creating
+// it on first start.
+func loadHeartbeatKey() {
+	TestEnv("OBC_HEARTBEAT_KEY", &heartbeatKeyPath)
+
+	raw, err := os.ReadFile(heartbeatKeyPath)
+
+	if errors.Is(err, os.ErrNotExist) {
+		heartbeatKey = make([]byte, 32)
+		_, err = rand.Read(heartbeatKey)
+		if err != nil {
+			panic(fmt.Errorf("failed to generate heartbeat key: %w", err))
+		}
+
+		err = os.WriteFile(heartbeatKeyPath, []byte(base64.StdEncoding.EncodeToString(heartbeatKey)), 0600)
+		if err != nil {
+			panic(fmt.Errorf("failed to write heartbeat key: %w", err))
+		}
+
+		log.Info().Str("path", heartbeatKeyPath).Msg("generated heartbeat key")
+		return
+	}
+
+	if err != nil {
+		panic(fmt.Errorf("failed to read heartbeat key: %w", err))
+	}
+
+	heartbeatKey, err = base64.StdEncoding.DecodeString(string(raw))
+	if err != nil {
+		panic(fmt.Errorf("failed to decode heartbeat key: %w", err))
+	}
+}
+
+// heartbeatToken is the secret a resource's agent sends with its pings. The
+// operator hands it to the machine's setup out of band, see
+// runHeartbeatToken, so only machines set up for the resource can keep it
+// online.
+func heartbeatToken(id string) string {
+	mac := hmac.New(sha256.New, heartbeatKey)
+	mac.Write([]byte("obc-heartbeat-v1"))
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func validHeartbeatToken(id string, token string) bool {
+	return len(heartbeatKey) > 0 && hmac.Equal([]byte(heartbeatToken(id)), []byte(token))
+}
+
+// runHeartbeatToken prints the heartbeat tokens of the given resources, to be
+// passed to their setup script as OBC_HEARTBEAT_TOKEN:
+//
+//	OpenBlockComputed heartbeat-token <id>...
+func runHeartbeatToken(ids []string) int {
+	if len(ids) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: OpenBlockComputed heartbeat-token <id>...")
+		return 1
+	}
+
+	loadHeartbeatKey()
+
+	for _, id := range ids {
+		fmt.Printf("%s %s\n", id, heartbeatToken(id))
+	}
+
+	return 0
+}
+
+// heartbeats collects agent pings between flushes, keyed by resource id.
+var heartbeats = struct {
+	sync.Mutex
+	seen map[string]int
+}{seen: make(map[string]int)}
+
+func recordHeartbeat(id string) {
+	heartbeats.Lock()
+	heartbeats.seen[id] = int(time.Now().UnixMicro())
+	heartbeats.Unlock()
+}
+
+// flushHeartbeats writes the pings collected since the last flush in one
+// transaction and then takes resources that stopped pinging offline.
+func flushHeartbeats(timeout time.Duration) {
+	heartbeats.Lock()
+	batch := make([]Heartbeat, 0, len(heartbeats.seen))
+	for id, t := range heartbeats.seen {
+		batch = append(batch, Heartbeat{Id: id, LastSeen: t})
+	}
+	heartbeats.seen = make(map[string]int)
+	heartbeats.Unlock()
+
+	_d, err := json.Marshal(batch)
+	if err != nil {
+		log.Err(err).Msg("failed to marshal heartbeats")
+		return
+	}
+
+	_, err = Invoke("ReportHeartbeats", string(_d), strconv.Itoa(int(timeout.Microseconds())))
+	if err != nil {
+		// keep the pings for the next flush unless newer ones arrived meanwhile
+		heartbeats.Lock()
+		for _, hb := range batch {
+			if hb.LastSeen > heartbeats.seen[hb.Id] {
+				heartbeats.seen[hb.Id] = hb.LastSeen
+			}
+		}
+		heartbeats.Unlock()
+	}
+
+	_, err = Invoke("MarkOffline", strconv.Itoa(int(timeout.Microseconds())))
+	if err != nil {
+		log.Err(err).Msg("failed to mark resources offline")
+	}
+}
+
+func startHeartbeatFlusher() {
+	TestEnv("OBC_HEARTBEAT_FLUSH", &heartbeatFlush)
+	TestEnv("OBC_HEARTBEAT_TIMEOUT", &heartbeatTimeout)
+
+	flush, err := time.ParseDuration(heartbeatFlush)
+	if err != nil {
+		panic(err)
+	}
+
+	timeout, err := time.ParseDuration(heartbeatTimeout)
+	if err != nil {
+		panic(err)
+	}
+
+	go func() {
+		for range time.Tick(flush) {
+			flushHeartbeats(timeout)
+		}
+	}()
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ManifestRow is one machine of an inventory manifest. Spec is passed to the
+// chaincode as is, see HardwareSpec there.
+type ManifestRow struct {
+	ExternalId string            `json:"external_id" yaml:"external_id"`
+	Name       string            `json:"name" yaml:"name"`
+	Labels     map[string]string `json:"labels,omitempty" yaml:"labels"`
+	Spec       map[string]any    `json:"spec,omitempty" yaml:"spec"`
+	SSH        *SSHAccessDetails `json:"ssh,omitempty" yaml:"ssh"`
+}
+
+type importRow struct {
+	ExternalId string            `json:"ExternalId"`
+	Name       string            `json:"Name"`
+	Labels     map[string]string `json:"Labels,omitempty"`
+	Spec       map[string]any    `json:"Spec,omitempty"`
+}
+
+type ImportResult struct {
+	ExternalId string `json:"ExternalId"`
+	Id         string `json:"Id"`
+	Status     string `json:"Status"`
+	Error      string `json:"Error"`
+}
+
+var importBatch = "50"
+
+// csvSpecColumns map manifest columns to integer HardwareSpec fields.
+var csvSpecColumns = map[string]string{
+	"cores":     "Cores",
+	"threads":   "Threads",
+	"sockets":   "Sockets",
+	"memory":    "Memory",
+	"nic_speed": "NicSpeed",
+}
+
+// parseCSVManifest reads a manifest with a header row. Known columns are
+// external_id, name, labels (as k=v;k=v), os, arch, cpu_model, cores,
+// threads, sockets, memory (bytes), nic_speed, ip, hostname, ssh_addr,
+// ssh_user, ssh_pass and ssh_idn.
+func parseCSVManifest(r io.Reader) ([]ManifestRow, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, fmt.Errorf("the manifest is empty")
+	}
+
+	header := records[0]
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+
+	rows := []ManifestRow{}
+	for n, record := range records[1:] {
+		row := ManifestRow{}
+		spec := map[string]any{}
+		ssh := SSHAccessDetails{}
+
+		for i, v := range record {
+			v = strings.TrimSpace(v)
+			if i >= len(header) || v == "" {
+				continue
+			}
+
+			switch col := header[i]; col {
+			case "external_id":
+				row.ExternalId = v
+			case "name":
+				row.Name = v
+			case "labels":
+				row.Labels = map[string]string{}
+				for _, kv := range strings.Split(v, ";") {
+					k, val, ok := strings.Cut(kv, "=")
+					if !ok {
+						return nil, fmt.Errorf("row %d: invalid label %q", n+1, kv)
+					}
+					row.Labels[strings.TrimSpace(k)] = strings.TrimSpace(val)
+				}
+			case "os":
+				spec["Os"] = v
+			case "arch":
+				spec["Arch"] = v
+			case "cpu_model":
+				spec["CpuModel"] = v
+			case "ip":
+				spec["Ip"] = v
+			case "hostname":
+				spec["Hostname"] = v
+			case "ssh_addr":
+				ssh.Addr = v
+			case "ssh_user":
+				ssh.User = v
+			case "ssh_pass":
+				ssh.Pass = v
+			case "ssh_idn":
+				ssh.Idn = v
+			default:
+				field, ok := csvSpecColumns[col]
+				if !ok {
+					return nil, fmt.Errorf("unknown column %s", col)
+				}
+				_v, err := strconv.Atoi(v)
+				if err != nil {
+					return nil, fmt.Errorf("row %d: invalid %s %q", n+1, col, v)
+				}
+				spec[field] = _v
+			}
+		}
+
+		if len(spec) > 0 {
+			row.Spec = spec
+		}
+		if ssh != (SSHAccessDetails{}) {
+			row.SSH = &ssh
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// importManifest imports rows in batches of OBC_IMPORT_BATCH rows per
+// transaction and returns one result per row. The rows of a batch whose
+// transaction fails are all reported as failed.
+func importManifest(rows []ManifestRow) ([]ImportResult, error) {
+	TestEnv("OBC_IMPORT_BATCH", &importBatch)
+
+	batch, err := strconv.Atoi(importBatch)
+	if err != nil || batch < 1 {
+		return nil, fmt.Errorf("invalid import batch size %q", importBatch)
+	}
+
+	// rows of later batches would silently update the ones of earlier batches
+	seen := map[string]bool{}
+	for _, row := range rows {
+		if seen[row.ExternalId] {
+			return nil, fmt.Errorf("duplicate external id %s in the manifest", row.ExternalId)
+		}
+		seen[row.ExternalId] = true
+	}
+
+	results := []ImportResult{}
+	for start := 0; start < len(rows); start += batch {
+		end := min(start+batch, len(rows))
+		results = append(results, importBatchRows(rows[start:end])...)
+	}
+
+	return results, nil
+}
+
+func importBatchRows(rows []ManifestRow) []ImportResult {
+	failed := func(err error) []ImportResult {
+		results := []ImportResult{}
+		for _, row := range rows {
+			results = append(results, ImportResult{ExternalId: row.ExternalId, Status: "failed", Error: err.Error()})
+		}
+		return results
+	}
+
+	args := []importRow{}
+	ssh := map[string]json.RawMessage{}
+	for _, row := range rows {
+		args = append(args, importRow{
+			ExternalId: row.ExternalId,
+			Name:       row.Name,
+			Labels:     row.Labels,
+			Spec:       row.Spec,
+		})
+
+		if row.SSH != nil {
+			_d, err := sealCredentials(mspID, *row.SSH)
+			if err != nil {
+				return failed(err)
+			}
+			ssh[row.ExternalId] = _d
+		}
+	}
+
+	_a, err := json.Marshal(args)
+	if err != nil {
+		return failed(err)
+	}
+
+	_s, err := json.Marshal(ssh)
+	if err != nil {
+		return failed(err)
+	}
+
+	data, err := InvokeTransistent("ImportComputeRes", map[string][]byte{"ssh": _s}, string(_a))
+	if err != nil {
+		return failed(err)
+	}
+
+	var results []ImportResult
+	err = json.Unmarshal(data, &results)
+	if err != nil {
+		return failed(err)
+	}
+
+	return results
+}
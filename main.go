@@ -23,9 +23,23 @@ var assetId = fmt.Sprintf("asset%d", now.Unix()*1e3+int64(now.Nanosecond())/1e6)
 func main() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 
+	// apply and the web server both seal credentials
+	loadCredentialKey()
+
+	if len(os.Args) > 1 && os.Args[1] == "apply" {
+		os.Exit(runApply(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "heartbeat-token" {
+		os.Exit(runHeartbeatToken(os.Args[2:]))
+	}
+
+	loadHeartbeatKey()
 	InitWebServer()
 
 	initLedger()
+	startHeartbeatFlusher()
+	startLogPurger()
 	createAsset(contract)
 	// getAllAssets(contract)
 
@@ -40,6 +54,8 @@ func main() {
 func initLedger() {
 	Invoke("CreateRootUser")
 
+	publishCredentialKey()
+
 }
 
 // Evaluate a transaction to query ledger state.
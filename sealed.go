@@ -0,0 +1,33 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+)
+
+// bidTransient prepares the "bid" transient of CommitBid. Without a salt a
+// random one is made; the chaincode keeps the bid in our implicit collection,
+// so it doesn't need to be remembered to reveal the bid later.
+func bidTransient(price string, salt string) ([]byte, string, error) {
+	_p, err := strconv.Atoi(price)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if salt == "" {
+		_s := make([]byte, 16)
+		if _, err := rand.Read(_s); err != nil {
+			return nil, "", err
+		}
+		salt = hex.EncodeToString(_s)
+	}
+
+	data, err := json.Marshal(map[string]any{"price": _p, "salt": salt})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, salt, nil
+}
@@ -36,7 +36,13 @@ func InvokeTransistent(a1 string, data map[string][]byte, args ...string) ([]byt
 
 	msg := checkErr(err)
 
-	log.Info().Str("func", a1).Strs("args", args).Any("data", data).AnErr("err", err).Msg("InvokeTransistent")
+	// transient values may hold credentials, only their keys are logged
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+
+	log.Info().Str("func", a1).Strs("args", args).Strs("transient", keys).AnErr("err", err).Msg("InvokeTransistent")
 
 	if err != nil {
 		return res, errors.Join(err, errors.New(msg))
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/hyperledger/fabric-gateway/pkg/client"
 	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
@@ -65,3 +66,25 @@ func checkErr(err error) string {
 	return detaild
 
 }
+
+// parseExpiry reads a grant expiry given either as an RFC3339 "expiry" or as
+// a "duration" from now. No expiry means the grant does not expire.
+func parseExpiry(result map[string]string) (int, error) {
+	if e := result["expiry"]; e != "" {
+		_e, err := time.Parse(time.RFC3339, e)
+		if err != nil {
+			return 0, err
+		}
+		return int(_e.UnixMicro()), nil
+	}
+
+	if d := result["duration"]; d != "" {
+		_d, err := time.ParseDuration(d)
+		if err != nil {
+			return 0, err
+		}
+		return int(time.Now().Add(_d).UnixMicro()), nil
+	}
+
+	return 0, nil
+}
@@ -71,7 +71,1724 @@ func InitWebServer() {
 	})
 
 	r.GET("/api/v1/listresources", func(c *gin.Context) {
-		data, err := Query("ListComputeRes")
+		// without query parameters the full list is returned as before
+		if len(c.Request.URL.Query()) == 0 {
+			data, err := Query("ListComputeRes")
+			if err != nil {
+				c.JSON(200, gin.H{
+					"message": "error",
+					"error":   err.Error(),
+				})
+				return
+			}
+			c.JSON(200, gin.H{
+				"message": "success",
+				"data":    string(data),
+			})
+			return
+		}
+
+		mincores, _ := strconv.Atoi(c.Query("mincores"))
+		minmemory, _ := strconv.Atoi(c.Query("minmemory"))
+		mingpus, _ := strconv.Atoi(c.Query("mingpus"))
+
+		filter, err := json.Marshal(map[string]any{
+			"Arch":      c.Query("arch"),
+			"Os":        c.Query("os"),
+			"MinCores":  mincores,
+			"MinMemory": minmemory,
+			"GpuModel":  c.Query("gpu"),
+			"MinGpus":   mingpus,
+			"State":     c.Query("state"),
+			"OwnerOrg":  c.Query("owner"),
+			"Selector":  c.Query("selector"),
+		})
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		desc := strconv.FormatBool(c.Query("order") == "desc")
+
+		data, err := Query("SearchComputeRes", string(filter), c.Query("sort"), desc, c.DefaultQuery("limit", "0"), c.Query("bookmark"))
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+	r.GET("/api/v1/resources/owned", func(c *gin.Context) {
+		data, err := Query("ListOwnedComputeRes")
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/resources/rented", func(c *gin.Context) {
+		data, err := Query("ListRentedComputeRes")
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/rebuildindexes", func(c *gin.Context) {
+		data, err := Invoke("RebuildIndexes")
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/queryresource/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+		data, err := Query("QueryComputeRes", id)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/deleteresource/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+		data, err := Invoke("DelComputeRes", id)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+	r.POST("/api/v1/heartbeat/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !validHeartbeatToken(id, token) {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   "invalid heartbeat token",
+			})
+			return
+		}
+
+		recordHeartbeat(id)
+
+		c.JSON(200, gin.H{
+			"message": "success",
+		})
+	})
+
+	r.GET("/api/v1/resourcehistory/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+		data, err := Query("GetComputeResHistory", id)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/resourcestate/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		var req struct {
+			State  string `json:"state"`
+			Reason string `json:"reason"`
+		}
+
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("SetComputeResState", id, req.State, req.Reason)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/maintenance/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		start, err := time.Parse(time.RFC3339, result["start"])
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		end, err := time.Parse(time.RFC3339, result["end"])
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("ScheduleMaintenance", id, strconv.Itoa(int(start.UnixMicro())), strconv.Itoa(int(end.UnixMicro())), result["reason"])
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/maintenance/cancel/:id/:window", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+		window := c.Params.ByName("window")
+		data, err := Invoke("CancelMaintenance", id, window)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/accesslogs/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		from, err := parseTimeQuery(c.Query("from"))
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		to, err := parseTimeQuery(c.Query("to"))
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		limit, _ := strconv.Atoi(c.Query("limit"))
+
+		data, err := Query("GetConnectionLogs", id, strconv.Itoa(from), strconv.Itoa(to), strconv.Itoa(limit), c.Query("bookmark"))
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/credits/balance", func(c *gin.Context) {
+		data, err := Query("GetCreditBalance")
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/credits/statement", func(c *gin.Context) {
+		from, err := parseTimeQuery(c.Query("from"))
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		to, err := parseTimeQuery(c.Query("to"))
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		limit, _ := strconv.Atoi(c.Query("limit"))
+
+		data, err := Query("GetCreditStatement", strconv.Itoa(from), strconv.Itoa(to), strconv.Itoa(limit), c.Query("bookmark"))
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/credits/transfer", func(c *gin.Context) {
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("TransferCredits", result["to"], result["amount"])
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/credits/mint", func(c *gin.Context) {
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("MintCredits", result["org"], result["amount"])
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/credits/issuer", func(c *gin.Context) {
+		data, err := Query("GetCreditIssuer")
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/credits/issuer", func(c *gin.Context) {
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("SetCreditIssuer", result["org"])
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	// the manifest is a JSON array of ManifestRow or, sent as text/csv, a CSV
+	// file as read by parseCSVManifest
+	r.POST("/api/v1/import", func(c *gin.Context) {
+		var rows []ManifestRow
+		var err error
+
+		if c.ContentType() == "text/csv" {
+			rows, err = parseCSVManifest(c.Request.Body)
+		} else {
+			err = c.BindJSON(&rows)
+		}
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		results, err := importManifest(rows)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := json.Marshal(results)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/createresource/:name", func(c *gin.Context) {
+		name := c.Params.ByName("name")
+		data, err := Invoke("CreateComputeRes", name)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/whoami", func(c *gin.Context) {
+		data, err := Query("GetUserInfo")
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/users/list", func(c *gin.Context) {
+		data, err := Query("ListUsers")
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/users/add", func(c *gin.Context) {
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("AddUser", result["user"], result["role"])
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/users/remove", func(c *gin.Context) {
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("RemoveUser", result["user"])
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/users/role", func(c *gin.Context) {
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("SetUserRole", result["user"], result["role"])
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/users/disable", func(c *gin.Context) {
+		var req struct {
+			User     string `json:"user"`
+			Disabled *bool  `json:"disabled"`
+		}
+
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		// leaving disabled out disables the user
+		disabled := req.Disabled == nil || *req.Disabled
+
+		data, err := Invoke("SetUserDisabled", req.User, strconv.FormatBool(disabled))
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/users/grant", func(c *gin.Context) {
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		expiry, err := parseExpiry(result)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("GrantAccess", result["user"], result["id"], strconv.Itoa(expiry))
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/users/revoke", func(c *gin.Context) {
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("RevokeAccess", result["user"], result["id"])
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/roles/list", func(c *gin.Context) {
+		data, err := Query("ListRoles")
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/roles/set", func(c *gin.Context) {
+		var result struct {
+			Name        string   `json:"name"`
+			Permissions []string `json:"permissions"`
+		}
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		if result.Permissions == nil {
+			result.Permissions = []string{}
+		}
+
+		perms, err := json.Marshal(result.Permissions)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("SetRole", result.Name, string(perms))
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/roles/delete", func(c *gin.Context) {
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("DeleteRole", result["name"])
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/policies/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+		data, err := Query("GetAccessPolicies", id)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/policies/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		var result []map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		if result == nil {
+			result = []map[string]string{}
+		}
+
+		_d, err := json.Marshal(result)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("SetAccessPolicies", id, string(_d))
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/updateresource/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+
+		}
+
+		_d, err := json.Marshal(result)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := InvokeTransistent("UpdateComputeRes", map[string][]byte{"update": _d}, id)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/updateresourcespec/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		var spec json.RawMessage
+
+		if err := c.BindJSON(&spec); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := InvokeTransistent("UpdateComputeRes", map[string][]byte{"spec": spec}, id)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/updateresourcessh/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+
+		}
+
+		_d, err := sealCredentials(mspID, SSHAccessDetails{
+			User: result["user"],
+			Pass: result["pass"],
+			Addr: result["addr"],
+			Idn:  result["idn"],
+		})
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := InvokeTransistent("UpdateComputeRes", map[string][]byte{"ssh": _d}, id)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/claimresource/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+		data, err := Invoke("ClaimRent", id)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/market/put/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		t := result["duration"]
+
+		if t == "" {
+			t = "0"
+		}
+
+		_t, err := time.ParseDuration(t)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("PutOnMarket", id, strconv.Itoa(int(_t.Microseconds())), result["price"])
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/market/sell/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("SellOnMarket", id, result["price"])
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/market/putsealed/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		t := result["duration"]
+
+		if t == "" {
+			t = "0"
+		}
+
+		_t, err := time.ParseDuration(t)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		_c, err := parseTimeQuery(result["close"])
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		_r, err := parseTimeQuery(result["reveal"])
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("PutSealedOnMarket", id, result["type"], strconv.Itoa(int(_t.Microseconds())), result["price"], strconv.Itoa(_c), strconv.Itoa(_r))
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/market/putfixed/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		_t, err := time.ParseDuration(result["duration"])
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		_o, err := offerCredentials(id)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := InvokeTransistent("PutFixedOnMarket", map[string][]byte{"ssh": _o}, id, strconv.Itoa(int(_t.Microseconds())), result["price"])
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/market/putslice/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		var result struct {
+			Duration string `json:"duration"`
+			Price    string `json:"price"`
+			Gpus     int    `json:"gpus"`
+			Cores    int    `json:"cores"`
+			Memory   int    `json:"memory"`
+		}
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		_t, err := time.ParseDuration(result.Duration)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		units, err := json.Marshal(map[string]int{
+			"gpus":   result.Gpus,
+			"cores":  result.Cores,
+			"memory": result.Memory,
+		})
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("PutSliceOnMarket", id, strconv.Itoa(int(_t.Microseconds())), result.Price, string(units))
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/market/delete/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		data, err := Invoke("RemoveFromMarket", id)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+	r.GET("/api/v1/market/get/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		data, err := Query("GetMarketElement", id)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/market/price/:id/:price", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+		price := c.Params.ByName("price")
+
+		data, err := Invoke("MakePrice", id, price)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/market/commit/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		_b, salt, err := bidTransient(result["price"], result["salt"])
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		_, err = InvokeTransistent("CommitBid", map[string][]byte{"bid": _b}, id)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    salt,
+		})
+	})
+
+	r.GET("/api/v1/market/reveal/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		data, err := Invoke("RevealBid", id)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/market/accept/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		data, err := Invoke("AcceptFixedPrice", id)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/market/lock/:id/:winner/:price", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+		winner := c.Params.ByName("winner")
+		price := c.Params.ByName("price")
+
+		data, err := Invoke("LockMarketElement", id, winner, price)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/market/end/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		transient, err := sealForWinner(id, nil)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := InvokeTransistent("EndMarketElement", transient, id)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/market/end/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		transient, err := sealForWinner(id, result)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := InvokeTransistent("EndMarketElement", transient, id)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/market/terms/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		_c, err := parseTimeQuery(result["close"])
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("SetAuctionTerms", id, strconv.Itoa(_c), result["policy"])
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/market/winner/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		data, err := Query("PickWinner", id)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/market/settle/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		transient, err := sealForWinner(id, nil)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := InvokeTransistent("SettleMarketElement", transient, id)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/market/settle/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		transient, err := sealForWinner(id, result)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := InvokeTransistent("SettleMarketElement", transient, id)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/market/termination/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		t := result["notice"]
+
+		if t == "" {
+			t = "0"
+		}
+
+		_t, err := time.ParseDuration(t)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("SetTerminationRules", id, strconv.Itoa(int(_t.Microseconds())), result["refund"])
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.POST("/api/v1/rental/extend/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		_t, err := time.ParseDuration(result["duration"])
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("RequestExtension", id, strconv.Itoa(int(_t.Microseconds())), result["price"])
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/rental/accept/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		data, err := Invoke("AcceptExtension", id)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/rental/decline/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		data, err := Invoke("DeclineExtension", id)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/rental/terminate/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		data, err := Invoke("TerminateRental", id)
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
+
+	r.GET("/api/v1/market/list", func(c *gin.Context) {
+
+		var data []byte
+		var err error
+
+		if status := c.Query("status"); status != "" {
+			data, err = Query("ListMarketElementsByStatus", status)
+		} else {
+			data, err = Query("ListMarketElements")
+		}
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+
+	})
+	r.GET("/api/v1/approvals/list", func(c *gin.Context) {
+		data, err := Query("ListPendingOperations")
 		if err != nil {
 			c.JSON(200, gin.H{
 				"message": "error",
@@ -84,9 +1801,10 @@ func InitWebServer() {
 			"data":    string(data),
 		})
 	})
-	r.GET("/api/v1/queryresource/:id", func(c *gin.Context) {
+
+	r.GET("/api/v1/approvals/get/:id", func(c *gin.Context) {
 		id := c.Params.ByName("id")
-		data, err := Query("QueryComputeRes", id)
+		data, err := Query("GetOperation", id)
 		if err != nil {
 			c.JSON(200, gin.H{
 				"message": "error",
@@ -100,9 +1818,50 @@ func InitWebServer() {
 		})
 	})
 
-	r.GET("/api/v1/deleteresource/:id", func(c *gin.Context) {
-		id := c.Params.ByName("id")
-		data, err := Invoke("DelComputeRes", id)
+	r.POST("/api/v1/approvals/propose", func(c *gin.Context) {
+		var result struct {
+			Function string   `json:"function"`
+			Args     []string `json:"args"`
+			TTL      string   `json:"ttl"`
+		}
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		t := result.TTL
+
+		if t == "" {
+			t = "0"
+		}
+
+		_t, err := time.ParseDuration(t)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		if result.Args == nil {
+			result.Args = []string{}
+		}
+
+		args, err := json.Marshal(result.Args)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		data, err := Invoke("ProposeOperation", result.Function, string(args), strconv.Itoa(int(_t.Microseconds())))
 		if err != nil {
 			c.JSON(200, gin.H{
 				"message": "error",
@@ -115,9 +1874,18 @@ func InitWebServer() {
 			"data":    string(data),
 		})
 	})
-	r.GET("/api/v1/accesslogs/:id", func(c *gin.Context) {
+
+	r.GET("/api/v1/approvals/approve/:id", func(c *gin.Context) {
 		id := c.Params.ByName("id")
-		data, err := Query("GetConnectionLogs", id)
+		transient, err := approvalTransient(id, nil)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		data, err := InvokeTransistent("ApproveOperation", transient, id)
 		if err != nil {
 			c.JSON(200, gin.H{
 				"message": "error",
@@ -131,9 +1899,28 @@ func InitWebServer() {
 		})
 	})
 
-	r.GET("/api/v1/createresource/:name", func(c *gin.Context) {
-		name := c.Params.ByName("name")
-		data, err := Invoke("CreateComputeRes", name)
+	r.POST("/api/v1/approvals/approve/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		transient, err := approvalTransient(id, result)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		data, err := InvokeTransistent("ApproveOperation", transient, id)
 		if err != nil {
 			c.JSON(200, gin.H{
 				"message": "error",
@@ -147,8 +1934,9 @@ func InitWebServer() {
 		})
 	})
 
-	r.GET("/api/v1/whoami", func(c *gin.Context) {
-		data, err := Query("GetUserInfo")
+	r.GET("/api/v1/approvals/cancel/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+		data, err := Invoke("CancelOperation", id)
 		if err != nil {
 			c.JSON(200, gin.H{
 				"message": "error",
@@ -162,10 +1950,26 @@ func InitWebServer() {
 		})
 	})
 
-	r.POST("/api/v1/updateresource/:id", func(c *gin.Context) {
-		id := c.Params.ByName("id")
+	r.GET("/api/v1/approvals/policy", func(c *gin.Context) {
+		data, err := Query("GetApprovalPolicy")
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
 
-		var result map[string]string
+	r.POST("/api/v1/approvals/policy", func(c *gin.Context) {
+		var result struct {
+			Threshold  int      `json:"threshold"`
+			Operations []string `json:"operations"`
+		}
 
 		if err := c.BindJSON(&result); err != nil {
 			c.JSON(200, gin.H{
@@ -173,11 +1977,13 @@ func InitWebServer() {
 				"error":   err.Error(),
 			})
 			return
-
 		}
 
-		_d, err := json.Marshal(result)
+		if result.Operations == nil {
+			result.Operations = []string{}
+		}
 
+		ops, err := json.Marshal(result.Operations)
 		if err != nil {
 			c.JSON(200, gin.H{
 				"message": "error",
@@ -186,8 +1992,7 @@ func InitWebServer() {
 			return
 		}
 
-		data, err := InvokeTransistent("UpdateComputeRes", map[string][]byte{"update": _d}, id)
-
+		data, err := Invoke("SetApprovalPolicy", strconv.Itoa(result.Threshold), string(ops))
 		if err != nil {
 			c.JSON(200, gin.H{
 				"message": "error",
@@ -201,7 +2006,7 @@ func InitWebServer() {
 		})
 	})
 
-	r.POST("/api/v1/updateresourcessh/:id", func(c *gin.Context) {
+	r.POST("/api/v1/labels/:id", func(c *gin.Context) {
 		id := c.Params.ByName("id")
 
 		var result map[string]string
@@ -212,11 +2017,18 @@ func InitWebServer() {
 				"error":   err.Error(),
 			})
 			return
-
 		}
 
 		_d, err := json.Marshal(result)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
 
+		data, err := Invoke("SetLabels", id, string(_d))
 		if err != nil {
 			c.JSON(200, gin.H{
 				"message": "error",
@@ -224,9 +2036,15 @@ func InitWebServer() {
 			})
 			return
 		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
 
-		data, err := InvokeTransistent("UpdateComputeRes", map[string][]byte{"ssh": _d}, id)
+	r.GET("/api/v1/pools/list", func(c *gin.Context) {
 
+		data, err := Query("ListPools")
 		if err != nil {
 			c.JSON(200, gin.H{
 				"message": "error",
@@ -240,9 +2058,10 @@ func InitWebServer() {
 		})
 	})
 
-	r.GET("/api/v1/claimresource/:id", func(c *gin.Context) {
-		id := c.Params.ByName("id")
-		data, err := Invoke("ClaimRent", id)
+	r.GET("/api/v1/pools/get/:name", func(c *gin.Context) {
+		name := c.Params.ByName("name")
+
+		data, err := Query("ListPoolResources", name, c.Query("selector"))
 		if err != nil {
 			c.JSON(200, gin.H{
 				"message": "error",
@@ -256,10 +2075,11 @@ func InitWebServer() {
 		})
 	})
 
-	r.POST("/api/v1/market/put/:id", func(c *gin.Context) {
-		id := c.Params.ByName("id")
-
-		var result map[string]string
+	r.POST("/api/v1/pools/set", func(c *gin.Context) {
+		var result struct {
+			Name      string   `json:"name"`
+			Resources []string `json:"resources"`
+		}
 
 		if err := c.BindJSON(&result); err != nil {
 			c.JSON(200, gin.H{
@@ -269,14 +2089,33 @@ func InitWebServer() {
 			return
 		}
 
-		t := result["duration"]
+		_d, err := json.Marshal(result.Resources)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
 
-		if t == "" {
-			t = "0"
+		data, err := Invoke("SetPool", result.Name, string(_d))
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
 		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
 
-		_t, err := time.ParseDuration(t)
+	r.GET("/api/v1/pools/delete/:name", func(c *gin.Context) {
+		name := c.Params.ByName("name")
 
+		data, err := Invoke("DeletePool", name)
 		if err != nil {
 			c.JSON(200, gin.H{
 				"message": "error",
@@ -284,9 +2123,24 @@ func InitWebServer() {
 			})
 			return
 		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    string(data),
+		})
+	})
 
-		data, err := Invoke("PutOnMarket", id, strconv.Itoa(int(_t.Microseconds())), result["price"])
+	r.POST("/api/v1/pools/grant", func(c *gin.Context) {
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
 
+		expiry, err := parseExpiry(result)
 		if err != nil {
 			c.JSON(200, gin.H{
 				"message": "error",
@@ -295,17 +2149,34 @@ func InitWebServer() {
 			return
 		}
 
+		data, err := Invoke("GrantPoolAccess", result["user"], result["pool"], strconv.Itoa(expiry))
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
 		c.JSON(200, gin.H{
 			"message": "success",
 			"data":    string(data),
 		})
 	})
 
-	r.GET("/api/v1/market/delete/:id", func(c *gin.Context) {
-		id := c.Params.ByName("id")
+	r.POST("/api/v1/pools/market/:name", func(c *gin.Context) {
+		name := c.Params.ByName("name")
 
-		data, err := Invoke("RemoveFromMarket", id)
+		var result map[string]string
+
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
 
+		_t, err := time.ParseDuration(result["duration"])
 		if err != nil {
 			c.JSON(200, gin.H{
 				"message": "error",
@@ -314,16 +2185,24 @@ func InitWebServer() {
 			return
 		}
 
+		data, err := Invoke("PutPoolOnMarket", name, strconv.Itoa(int(_t.Microseconds())), result["price"])
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
 		c.JSON(200, gin.H{
 			"message": "success",
 			"data":    string(data),
 		})
 	})
-	r.GET("/api/v1/market/get/:id", func(c *gin.Context) {
-		id := c.Params.ByName("id")
 
-		data, err := Query("GetMarketElement", id)
+	r.GET("/api/v1/pools/claim/:name", func(c *gin.Context) {
+		name := c.Params.ByName("name")
 
+		data, err := Invoke("ClaimPoolRent", name)
 		if err != nil {
 			c.JSON(200, gin.H{
 				"message": "error",
@@ -331,19 +2210,16 @@ func InitWebServer() {
 			})
 			return
 		}
-
 		c.JSON(200, gin.H{
 			"message": "success",
 			"data":    string(data),
 		})
 	})
 
-	r.GET("/api/v1/market/price/:id/:price", func(c *gin.Context) {
+	r.GET("/api/v1/history/:kind/:id", func(c *gin.Context) {
+		kind := c.Params.ByName("kind")
 		id := c.Params.ByName("id")
-		price := c.Params.ByName("price")
-
-		data, err := Invoke("MakePrice", id, price)
-
+		data, err := Query("GetChangeHistory", kind, id)
 		if err != nil {
 			c.JSON(200, gin.H{
 				"message": "error",
@@ -351,20 +2227,16 @@ func InitWebServer() {
 			})
 			return
 		}
-
 		c.JSON(200, gin.H{
 			"message": "success",
 			"data":    string(data),
 		})
 	})
 
-	r.GET("/api/v1/market/lock/:id/:winner/:price", func(c *gin.Context) {
+	r.GET("/api/v1/history/:kind/:id/verify", func(c *gin.Context) {
+		kind := c.Params.ByName("kind")
 		id := c.Params.ByName("id")
-		winner := c.Params.ByName("winner")
-		price := c.Params.ByName("price")
-
-		data, err := Invoke("LockMarketElement", id, winner, price)
-
+		data, err := Query("VerifyChangeHistory", kind, id)
 		if err != nil {
 			c.JSON(200, gin.H{
 				"message": "error",
@@ -372,18 +2244,24 @@ func InitWebServer() {
 			})
 			return
 		}
-
 		c.JSON(200, gin.H{
 			"message": "success",
 			"data":    string(data),
 		})
 	})
 
-	r.GET("/api/v1/market/end/:id", func(c *gin.Context) {
-		id := c.Params.ByName("id")
+	r.POST("/api/v1/accesslogs/purge", func(c *gin.Context) {
+		var result map[string]string
 
-		data, err := Invoke("EndMarketElement", id)
+		if err := c.BindJSON(&result); err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
 
+		before, err := parseTimeQuery(result["before"])
 		if err != nil {
 			c.JSON(200, gin.H{
 				"message": "error",
@@ -392,16 +2270,42 @@ func InitWebServer() {
 			return
 		}
 
+		if d := result["olderthan"]; d != "" {
+			_d, err := time.ParseDuration(d)
+			if err != nil {
+				c.JSON(200, gin.H{
+					"message": "error",
+					"error":   err.Error(),
+				})
+				return
+			}
+			before = int(time.Now().Add(-_d).UnixMicro())
+		}
+
+		if before == 0 {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   "before or olderthan is required",
+			})
+			return
+		}
+
+		data, err := Invoke("PurgeConnectionLogs", result["id"], strconv.Itoa(before))
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
 		c.JSON(200, gin.H{
 			"message": "success",
 			"data":    string(data),
 		})
 	})
 
-	r.GET("/api/v1/market/list", func(c *gin.Context) {
-
-		data, err := Query("ListMarketElements")
-
+	r.POST("/api/v1/accesslogs/migrate", func(c *gin.Context) {
+		data, err := Invoke("MigrateAccessLogs")
 		if err != nil {
 			c.JSON(200, gin.H{
 				"message": "error",
@@ -409,13 +2313,27 @@ func InitWebServer() {
 			})
 			return
 		}
-
 		c.JSON(200, gin.H{
 			"message": "success",
 			"data":    string(data),
 		})
+	})
 
+	r.POST("/api/v1/migratecredentials", func(c *gin.Context) {
+		n, err := migrateCredentials()
+		if err != nil {
+			c.JSON(200, gin.H{
+				"message": "error",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data":    strconv.Itoa(n),
+		})
 	})
+
 	r.GET("/api/v1/access/:id", connectToBackend)
 
 	r.NoRoute(func(c *gin.Context) {